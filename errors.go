@@ -0,0 +1,143 @@
+package parsekit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseError is an error found while parsing, attached to its position in the source.
+type ParseError struct {
+	pos  Position
+	msg  string
+	line string // source line holding pos
+	lcol int    // byte offset of pos in line
+	err  error  // underlying error, if any
+	warn bool   // recorded with [Parser.Warnf]
+}
+
+// Position returns the position in source where the error occurred.
+func (e ParseError) Position() Position { return e.pos }
+
+// Message returns the error message, without position information.
+func (e ParseError) Message() string { return e.msg }
+
+// Severity returns "warning" for diagnostics recorded by [Parser.Warnf], and "error" otherwise.
+func (e ParseError) Severity() string {
+	if e.warn {
+		return "warning"
+	}
+	return "error"
+}
+
+// Unwrap returns the underlying error, from [Parser.WrapErrf] or from the lexer, if any.
+func (e ParseError) Unwrap() error { return e.err }
+
+// Error implements error.
+func (e ParseError) Error() string { return fmt.Sprintf("at %s: %s", e.pos, e.msg) }
+
+// Snippet returns the error message, followed by the offending source line and a caret under the error column:
+//
+//	at config:2:11: expected ";", got "}" instead
+//	  interface }
+//	            ^
+func (e ParseError) Snippet() string {
+	var b strings.Builder
+	b.WriteString(e.Error())
+	b.WriteByte('\n')
+	b.WriteString(e.line)
+	b.WriteByte('\n')
+	// keep tabs, so the caret is aligned whatever the terminal tab width
+	for _, r := range e.line[:e.lcol] {
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('^')
+	return b.String()
+}
+
+// ParseErrors is the list of errors returned by [Parser.Finish], in the order they were found.
+// Use errors.As to enumerate them:
+//
+//	var errs parsekit.ParseErrors
+//	if errors.As(err, &errs) {
+//	   for _, e := range errs {
+//	      report(e.Position(), e.Message())
+//	   }
+//	}
+type ParseErrors []ParseError
+
+// Error implements error, with one error per line.
+func (e ParseErrors) Error() string {
+	msg := make([]string, len(e))
+	for i, e := range e {
+		msg[i] = e.Error()
+	}
+	return strings.Join(msg, "\n")
+}
+
+// Unwrap gives access to the individual errors with errors.Is and errors.As.
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, e := range e {
+		errs[i] = e
+	}
+	return errs
+}
+
+// diagnostic is the JSON form of a [ParseError], see [MarshalDiagnostics].
+type diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Offset   int    `json:"offset"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+}
+
+// MarshalDiagnostics returns the diagnostics in errs as a JSON array, for editors and CI tools:
+//
+//	[{"file":"dhcpd.conf","line":2,"column":11,"offset":24,"message":"expected \";\", got \"}\" instead","severity":"error"}]
+//
+// errs is typically the error returned by [Parser.Finish], possibly joined with the warnings:
+//
+//	_, err := p.Finish()
+//	out, _ := parsekit.MarshalDiagnostics(errors.Join(err, parsekit.ParseErrors(p.Warnings())))
+//
+// Wrapping errors are looked through for the diagnostics they carry (e.g. with fmt.Errorf and %w).
+// Errors other than [ParseError] are kept, without a position.
+func MarshalDiagnostics(errs error) ([]byte, error) {
+	diags := []diagnostic{}
+	var walk func(err error)
+	walk = func(err error) {
+		switch err := err.(type) {
+		case nil:
+		case ParseError:
+			diags = append(diags, diagnostic{
+				File:     err.pos.Filename,
+				Line:     err.pos.Line,
+				Column:   err.pos.Column,
+				Offset:   err.pos.Offset,
+				Message:  err.msg,
+				Severity: err.Severity(),
+			})
+		case interface{ Unwrap() []error }:
+			for _, err := range err.Unwrap() {
+				walk(err)
+			}
+		default:
+			// e.g. fmt.Errorf("load config: %w", err): the context is dropped to keep the positions
+			if inner := errors.Unwrap(err); inner != nil && errors.As(inner, new(ParseError)) {
+				walk(inner)
+				return
+			}
+			diags = append(diags, diagnostic{Message: err.Error(), Severity: "error"})
+		}
+	}
+	walk(errs)
+	return json.Marshal(diags)
+}
@@ -2,7 +2,10 @@ package parsekit_test
 
 import (
 	"fmt"
+	"io"
 	"net/netip"
+	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -46,17 +49,14 @@ func ParseLease(p *parsekit.Parser[Lease]) {
 		p.Expect(IdentToken, "option")
 		switch p.Lit() {
 		case "interface":
-			p.Expect(StringToken, "interface")
-			p.Value.Interface = p.Val().(string)
+			p.Value.Interface = parsekit.ExpectValue[Lease, string](p, StringToken, "interface")
 			p.Expect(';', ";")
 		case "fixed-address":
-			p.Expect(IPToken, "IP address")
-			p.Value.FixedAddress = p.Val().(netip.Addr)
+			p.Value.FixedAddress = parsekit.ExpectValue[Lease, netip.Addr](p, IPToken, "IP address")
 			p.Expect(';', ";")
 		case "expire":
 			p.Expect(NumberToken, "number")
-			p.Expect(DateTimeToken, "date and time of expiration")
-			p.Value.Expire = time.Time(p.Val().(LTime))
+			p.Value.Expire = parsekit.ExpectValue[Lease, time.Time](p, DateTimeToken, "date and time of expiration")
 			p.Expect(';', ";")
 		default:
 			for !p.Match(';') {
@@ -66,17 +66,6 @@ func ParseLease(p *parsekit.Parser[Lease]) {
 	}
 }
 
-type LTime time.Time
-
-func (t *LTime) UnmarshalText(dt []byte) error {
-	u, err := time.Parse("2006/01/02 15:04:05", string(dt))
-	if err != nil {
-		return err
-	}
-	*t = (LTime)(u)
-	return nil
-}
-
 const (
 	NumberToken rune = -1 - iota
 	IPToken
@@ -99,7 +88,7 @@ func scantk(sc *parsekit.Scanner) parsekit.Token {
 			sc.Advance()
 		}
 		if sc.Peek() == utf8.RuneError {
-			return parsekit.EOF
+			return parsekit.Errorf("unterminated string")
 		}
 		sc.Advance() // terminating '"'
 		return parsekit.Auto[string](StringToken, sc)
@@ -123,7 +112,7 @@ func scantk(sc *parsekit.Scanner) parsekit.Token {
 		}
 		switch guess {
 		case DateTimeToken:
-			return parsekit.Auto[LTime](guess, sc)
+			return parsekit.AutoTime(guess, "2006/01/02 15:04:05", sc)
 		case IPToken:
 			return parsekit.Auto[netip.Addr](guess, sc)
 		default:
@@ -139,3 +128,24 @@ func scantk(sc *parsekit.Scanner) parsekit.Token {
 
 	return parsekit.EOF
 }
+
+var scanners = sync.Pool{New: func() any { return parsekit.ScanReader(io.NopCloser(strings.NewReader(""))) }}
+
+func ExampleScanner_Reset() {
+	count := func(msg string) int {
+		sc := scanners.Get().(*parsekit.Scanner)
+		defer scanners.Put(sc)
+
+		sc.Reset(strings.NewReader(msg))
+		n := 0
+		for tk := range sc.Tokens(scantk) {
+			if tk.Type == IdentToken {
+				n++
+			}
+		}
+		return n
+	}
+
+	fmt.Println(count(`interface "eth0";`), count("option routers 10.67.21.1;"))
+	// Output: 1 2
+}
@@ -0,0 +1,351 @@
+package parsekit
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file contains helpers for common lexemes.
+// They are meant to be called from a [Lexer], and return the number of bytes read,
+// or 0 if the lexeme was not found at the read cursor (the scanner is then untouched).
+
+// SkipWhitespace reads the white space at the read cursor, as defined by [WithWhitespace]:
+// by default, spaces, tabs, carriage returns and newlines.
+// With [SignificantNewlines], newlines are not skipped.
+// It is typically called first in a lexer:
+//
+//	if sc.SkipWhitespace() > 0 {
+//		return parsekit.Ignore
+//	}
+func (s *Scanner) SkipWhitespace() int {
+	from := s.off
+	isspace := s.space
+	if isspace == nil {
+		isspace = IsSpace
+	}
+	if s.newlines {
+		s.AcceptFunc(func(r rune) bool { return r != '\n' && isspace(r) })
+	} else {
+		s.AcceptFunc(isspace)
+	}
+	s.last = 0
+	return s.off - from
+}
+
+// LexLineComment reads a comment starting with start (e.g. "//" or "#"), through the end of the line.
+//
+//	case sc.LexLineComment("#") > 0:
+//		return parsekit.Ignore
+func (s *Scanner) LexLineComment(start string) int {
+	if !s.AcceptString(start) {
+		return 0
+	}
+	return len(start) + s.SkipLine()
+}
+
+// LexBlockComment reads a comment between open and close (e.g. "/*" and "*/").
+// Comments do not nest: the first close ends the comment.
+// A comment missing its close runs to the end of input.
+func (s *Scanner) LexBlockComment(open, close string) int {
+	from := s.off
+	if !s.AcceptString(open) {
+		return 0
+	}
+	for !s.AcceptString(close) && len(s.fill(1)) > 0 {
+		s.Advance()
+	}
+	s.last = 0
+	return s.off - from
+}
+
+// LexNestedBlockComment reads a comment between open and close, where comments can nest (e.g. "/* /* */ */").
+// The comment ends when the close matching the first open is found.
+// A comment missing its close runs to the end of input.
+func (s *Scanner) LexNestedBlockComment(open, close string) int {
+	from := s.off
+	if !s.AcceptString(open) {
+		return 0
+	}
+	for depth := 1; depth > 0 && len(s.fill(1)) > 0; {
+		switch {
+		case s.AcceptString(close):
+			depth--
+		case s.AcceptString(open):
+			depth++
+		default:
+			s.Advance()
+		}
+	}
+	s.last = 0
+	return s.off - from
+}
+
+// LexNumber reads an integer literal: a decimal digit, followed by digits, letters and underscores.
+// This covers Go literals, with base prefixes (0x1F) and digit separators (1_000_000);
+// the literal is validated when converted with [Auto]:
+//
+//	case sc.LexNumber() > 0:
+//		return parsekit.Auto[int](NumberToken, sc)
+func (s *Scanner) LexNumber() int {
+	return s.LexIdentFunc(IsDigit, func(r rune) bool {
+		return r == '_' || IsDigit(r) || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+	})
+}
+
+// LexHeredoc reads a here document, after its opening marker (e.g. "EOF" in "<<EOF"):
+// the rest of the opening line, and the lines up to a line holding only marker, included.
+// The newline after the closing marker is not read.
+// A document missing its closing marker runs to the end of input, and ok is false.
+//
+//	case sc.AcceptString("<<") && sc.LexIdent() > 0:
+//		marker := sc.Cursor()[2:]
+//		if _, ok := sc.LexHeredoc(marker); !ok {
+//			return sc.Errorf("missing %s at the end of the document", marker)
+//		}
+//		return parsekit.Const(HeredocToken)
+func (s *Scanner) LexHeredoc(marker string) (n int, ok bool) {
+	from := s.off
+	// nextline advances past the next newline, and reports whether there is a line after it
+	nextline := func() bool {
+		for w := s.fill(1); len(w) > 0; w = s.fill(1) {
+			if i := bytes.IndexByte(w, '\n'); i >= 0 {
+				s.off += i + 1
+				return true
+			}
+			s.off += len(w)
+		}
+		return false
+	}
+
+	s.last = 0
+	for nextline() {
+		start := s.off
+		if s.AcceptString(marker) {
+			if w := s.fill(2); len(w) == 0 || w[0] == '\n' || bytes.HasPrefix(w, []byte("\r\n")) {
+				return s.off - from, true
+			}
+		}
+		s.off = start
+	}
+	return s.off - from, false
+}
+
+// identchars are the bytes of an identifier in [Scanner.LexIdent].
+var identchars = func() (t [256]bool) {
+	for c := 'a'; c <= 'z'; c++ {
+		t[c], t[c-'a'+'A'] = true, true
+	}
+	t['_'], t['-'] = true, true
+	return t
+}()
+
+// LexIdent reads an identifier made of ASCII letters, '_' and '-'.
+// Use [Scanner.LexIdentFunc] for other character sets.
+func (s *Scanner) LexIdent() int {
+	isident := func(r rune) bool { return r < 256 && identchars[r] }
+	return s.LexIdentFunc(isident, isident)
+}
+
+// LexIdentFunc reads an identifier starting with a character matching first, followed by characters matching rest.
+// For example, C-like identifiers ([A-Za-z_][A-Za-z0-9_]*) are read with:
+//
+//	letter := func(r rune) bool { return r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' }
+//	sc.LexIdentFunc(letter, func(r rune) bool { return letter(r) || '0' <= r && r <= '9' })
+func (s *Scanner) LexIdentFunc(first, rest func(rune) bool) int {
+	if r := s.Peek(); r == utf8.RuneError || !first(r) {
+		return 0
+	}
+
+	from := s.off
+	s.Advance()
+	s.AcceptFunc(rest)
+	s.last = 0
+	return s.off - from
+}
+
+// LexUnicodeIdent reads an identifier starting with a Unicode letter or '_',
+// followed by letters, digits, combining marks or '_'.
+// This is close to the identifiers of Go, or to Unicode UAX #31.
+func (s *Scanner) LexUnicodeIdent() int {
+	return s.LexIdentFunc(IsIdentStart, IsIdentPart)
+}
+
+// The predicates below classify characters as the lexers of this file do.
+// They are meant to build other lexers, e.g. with [Scanner.AcceptFunc].
+
+// IsIdentStart reports whether r can start an identifier read by [Scanner.LexUnicodeIdent].
+func IsIdentStart(r rune) bool { return r == '_' || unicode.IsLetter(r) }
+
+// IsIdentPart reports whether r can continue an identifier read by [Scanner.LexUnicodeIdent].
+func IsIdentPart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// IsSpace reports whether r is a space, a tab, a carriage return or a newline, the default white space of [Scanner.SkipWhitespace].
+func IsSpace(r rune) bool { return r == ' ' || r == '\t' || r == '\r' || r == '\n' }
+
+// IsQuote reports whether r opens a string read by [Scanner.LexString].
+func IsQuote(r rune) bool { return 0 <= r && r < 256 && quotechars[r] }
+
+// IsDigit reports whether r is an ASCII decimal digit.
+// Unlike [unicode.IsDigit], digits from other scripts are not accepted, as they are not numbers for [Auto].
+func IsDigit(r rune) bool { return '0' <= r && r <= '9' }
+
+// Keyword returns a token for the identifier just scanned: its type is the one listed in table, or defaultTok.
+//
+//	var keywords = map[string]rune{"if": IfToken, "else": ElseToken}
+//	case sc.LexIdent() > 0:
+//		return sc.Keyword(keywords, IdentToken)
+func (s *Scanner) Keyword(table map[string]rune, defaultTok rune) Token {
+	if tk, ok := table[s.Cursor()]; ok {
+		return Const(tk)
+	}
+	return Const(defaultTok)
+}
+
+// quotechars are the bytes opening a string in [Scanner.LexString].
+var quotechars = [256]bool{'"': true, '\'': true, '`': true}
+
+// LexString reads a string delimited by double quotes, single quotes, or backticks.
+// Inside double and single quotes, a backslash escapes the next character (e.g. \"); backtick strings are raw.
+// A string missing its closing quote runs to the end of input.
+func (s *Scanner) LexString() int {
+	n, _ := s.LexStringKind()
+	return n
+}
+
+// LexStringKind is like [Scanner.LexString], and also returns the opening quote,
+// so the lexer can tell raw strings apart:
+//
+//	n, q := sc.LexStringKind()
+//	switch {
+//	case n == 0:
+//		// not a string
+//	case q == '`':
+//		return sc.Emit(StringToken, func(s string) any { return s[1 : len(s)-1] })
+//	default:
+//		return parsekit.Auto[string](StringToken, sc)
+//	}
+func (s *Scanner) LexStringKind() (n int, quote byte) {
+	w := s.fill(1)
+	if len(w) == 0 || !quotechars[w[0]] {
+		return 0, 0
+	}
+
+	from := s.off
+	quote = w[0]
+	s.off++
+	for escaped := false; ; {
+		w := s.fill(1)
+		if len(w) == 0 {
+			break
+		}
+		c := w[0]
+		s.off++
+		if escaped {
+			escaped = false
+			continue
+		}
+		if c == quote {
+			break
+		}
+		escaped = c == '\\' && quote != '`'
+	}
+	s.last = 0
+	return s.off - from, quote
+}
+
+// LexStringStrict is like [Scanner.LexString], and also validates escape sequences.
+// The sequences accepted are those of Go strings (\n, \x41, \u00e9, \101, …), and the quote of the string escaped (\" or \'), as [Auto] does.
+// An invalid sequence or a missing closing quote is returned as an error, positioned at the fault;
+// wrap it in an error token so the parser reports it:
+//
+//	if _, err := sc.LexStringStrict(); err != nil {
+//		return parsekit.Errorf("%w", err)
+//	}
+//	return parsekit.Auto[string](StringToken, sc)
+//
+// The scanner advances to the end of the string in all cases.
+func (s *Scanner) LexStringStrict() (int, error) {
+	w := s.fill(1)
+	if len(w) == 0 || !quotechars[w[0]] {
+		return 0, nil
+	}
+
+	from := s.off
+	quote := w[0]
+	s.off++
+	var err error
+	for {
+		w := s.fill(1)
+		if len(w) == 0 {
+			s.last = 0
+			return s.off - from, s.errorAt(from, "unterminated string")
+		}
+		s.off++
+		switch {
+		case w[0] == quote:
+			s.last = 0
+			return s.off - from, err
+		case w[0] == '\\' && quote != '`':
+			if serr := s.lexEscape(quote); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	}
+}
+
+// lexEscape reads an escape sequence, after its backslash, in a string delimited by quote.
+func (s *Scanner) lexEscape(quote byte) error {
+	at := s.off - 1
+	// digits reads n digits in base, and returns their value, or -1
+	digits := func(n, base int) rune {
+		var v rune
+		for range n {
+			d := digitval(s.Peek())
+			if d >= base {
+				return -1
+			}
+			s.Advance()
+			v = v*rune(base) + rune(d)
+		}
+		return v
+	}
+
+	ok := true
+	switch c := s.Advance(); c {
+	case 'a', 'b', 'f', 'n', 'r', 't', 'v', '\\':
+	case '\'', '"':
+		ok = c == rune(quote)
+	case 'x':
+		ok = digits(2, 16) >= 0
+	case 'u':
+		ok = utf8.ValidRune(digits(4, 16))
+	case 'U':
+		ok = utf8.ValidRune(digits(8, 16))
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		s.Backup()
+		v := digits(3, 8)
+		ok = 0 <= v && v <= 255
+	default:
+		ok = false
+	}
+	if !ok {
+		return s.errorAt(at, "invalid escape sequence %s", s.Cursor()[at-s.start:])
+	}
+	return nil
+}
+
+// digitval returns the value of r as a hexadecimal digit, or 16.
+func digitval(r rune) int {
+	switch {
+	case '0' <= r && r <= '9':
+		return int(r - '0')
+	case 'a' <= r && r <= 'f':
+		return int(r - 'a' + 10)
+	case 'A' <= r && r <= 'F':
+		return int(r - 'A' + 10)
+	}
+	return 16
+}
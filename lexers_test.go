@@ -0,0 +1,279 @@
+package parsekit
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestLexLineComment(t *testing.T) {
+	cases := []struct {
+		start, in string
+		want      int
+	}{
+		{"#", "# comment\nx", 10},
+		{"#", "# at the end", 12},
+		{"#", "x # not at cursor", 0},
+		{"//", "// comment\n", 11},
+		{"//", "/ not quite", 0},
+	}
+	for _, c := range cases {
+		if n := scanString(c.in).LexLineComment(c.start); n != c.want {
+			t.Errorf("LexLineComment(%q, %q) = %d, want %d", c.start, c.in, n, c.want)
+		}
+	}
+}
+
+func TestLexBlockComment(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int
+	}{
+		{"/* comment */ x", 13},
+		{"/**/", 4},
+		{"/* a /* b */ c */", 12}, // comments do not nest
+		{"/* unterminated", 15},
+		{"x /* */", 0},
+	}
+	for _, c := range cases {
+		if n := scanString(c.in).LexBlockComment("/*", "*/"); n != c.want {
+			t.Errorf("LexBlockComment(%q) = %d, want %d", c.in, n, c.want)
+		}
+	}
+}
+
+func TestLexNestedBlockComment(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int
+	}{
+		{"/* flat */ x", 10},
+		{"/* a /* b */ c */ x", 17},         // depth 2
+		{"/* a /* b /* c */ */ d */ x", 25}, // depth 3
+		{"/* a /* b */ c", 14},              // unterminated
+		{"/* a /*/ b */ c */", 18},          // "/*/" opens, and does not close
+		{"x /* */", 0},
+	}
+	for _, c := range cases {
+		if n := scanString(c.in).LexNestedBlockComment("/*", "*/"); n != c.want {
+			t.Errorf("LexNestedBlockComment(%q) = %d, want %d", c.in, n, c.want)
+		}
+	}
+}
+
+func TestLexStringKind(t *testing.T) {
+	cases := []struct {
+		in    string
+		want  string
+		quote byte
+	}{
+		{`"abc" d`, `"abc"`, '"'},
+		{`"a\"bc" d`, `"a\"bc"`, '"'},
+		{`'a\'' d`, `'a\''`, '\''},
+		{"`a\\` d", "`a\\`", '`'}, // raw strings have no escapes
+		{`"é\\" d`, `"é\\"`, '"'},
+		{`"unterminated`, `"unterminated`, '"'},
+		{`x "abc"`, ``, 0},
+	}
+	for _, c := range cases {
+		sc := scanString(c.in)
+		n, q := sc.LexStringKind()
+		if got := sc.Cursor(); got != c.want || n != len(c.want) || q != c.quote {
+			t.Errorf("LexStringKind(%s) = %d, %q, read %s", c.in, n, q, got)
+		}
+	}
+}
+
+func TestLexStringStrict(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string // error, empty if none
+	}{
+		{`"a\n\t\"\\b"`, ""},
+		{`'\'' "\x41é\U0001F600\101"`, ""},
+		{"`\\q`", ""}, // raw strings have no escapes
+		{`"ab\qc"`, "1:4: invalid escape sequence \\q"},
+		{`"\x4g"`, "1:2: invalid escape sequence \\x4"},
+		{`"\ud800"`, "1:2: invalid escape sequence \\ud800"},
+		{`"\400"`, "1:2: invalid escape sequence \\400"},
+		{`"abc`, "1:1: unterminated string"},
+		{`"a\'b"`, "1:3: invalid escape sequence \\'"},
+		{`'a\"b'`, "1:3: invalid escape sequence \\\""},
+	}
+	for _, c := range cases {
+		sc := scanString(c.in)
+		sc.start = sc.off
+		_, err := sc.LexStringStrict()
+		got := ""
+		if err != nil {
+			pos := err.(interface{ Position() Position }).Position()
+			got = fmt.Sprintf("%d:%d: %s", pos.Line, pos.Column, err)
+		}
+		if got != c.want {
+			t.Errorf("LexStringStrict(%s) = %q, want %q", c.in, got, c.want)
+		}
+		// strings accepted are converted by Auto
+		if tk := Auto[string](stringTk, sc); err == nil && tk.Error() != nil {
+			t.Errorf("Auto[string](%s) = %v", sc.Cursor(), tk.Error())
+		}
+	}
+}
+
+func TestLexIdentFunc(t *testing.T) {
+	letter := func(r rune) bool { return r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' }
+	alnum := func(r rune) bool { return letter(r) || '0' <= r && r <= '9' }
+	cases := []struct {
+		in, want string
+	}{
+		{"var2 x", "var2"},
+		{"_a_1+", "_a_1"},
+		{"2var", ""},
+		{"-x", ""},
+	}
+	for _, c := range cases {
+		sc := scanString(c.in)
+		n := sc.LexIdentFunc(letter, alnum)
+		if got := sc.Cursor(); got != c.want || n != len(c.want) {
+			t.Errorf("LexIdentFunc(%s) = %d, read %q", c.in, n, got)
+		}
+	}
+
+	sc := scanString("my-var2")
+	if n := sc.LexIdent(); n != 6 || sc.Cursor() != "my-var" {
+		t.Errorf("LexIdent() = %d, read %q", n, sc.Cursor())
+	}
+}
+
+func TestLexUnicodeIdent(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"λόγος2 x", "λόγος2"},
+		{"名前=1", "名前"},
+		{"_é", "_é"},
+		{"2λ", ""},
+	}
+	for _, c := range cases {
+		sc := scanString(c.in)
+		n := sc.LexUnicodeIdent()
+		if got := sc.Cursor(); got != c.want || n != len(c.want) {
+			t.Errorf("LexUnicodeIdent(%s) = %d, read %q", c.in, n, got)
+		}
+	}
+}
+
+func TestKeyword(t *testing.T) {
+	const (
+		identTk = -1 - iota
+		ifTk
+		elseTk
+	)
+	keywords := map[string]rune{"if": ifTk, "else": elseTk}
+	lex := func(sc *Scanner) Token {
+		if sc.LexIdent() > 0 {
+			return sc.Keyword(keywords, identTk)
+		}
+		sc.Advance()
+		return Ignore
+	}
+
+	var got []rune
+	for tk := range scanString("if x else iffy").Tokens(lex) {
+		got = append(got, tk.Type)
+	}
+	if want := []rune{ifTk, identTk, elseTk, identTk, 0}; !slices.Equal(got, want) {
+		t.Errorf("token types = %v, want %v", got, want)
+	}
+}
+
+func TestPredicates(t *testing.T) {
+	cases := []struct {
+		name string
+		pred func(rune) bool
+		yes  string
+		no   string
+	}{
+		{"IsIdentStart", IsIdentStart, "aZ_éλ名", "1-. \u0301"},
+		{"IsIdentPart", IsIdentPart, "aZ_é1\u0301", "-. \""},
+		{"IsQuote", IsQuote, "\"'`", "a«\u0122"},
+		{"IsDigit", IsDigit, "0123456789", "a٣"},
+	}
+	for _, c := range cases {
+		for _, r := range c.yes {
+			if !c.pred(r) {
+				t.Errorf("%s(%q) = false", c.name, r)
+			}
+		}
+		for _, r := range c.no {
+			if c.pred(r) {
+				t.Errorf("%s(%q) = true", c.name, r)
+			}
+		}
+	}
+}
+
+func TestSkipWhitespace(t *testing.T) {
+	sc := scanString(" \t\r\n a")
+	if n := sc.SkipWhitespace(); n != 5 || sc.Peek() != 'a' {
+		t.Errorf("SkipWhitespace() = %d, at %q", n, sc.Peek())
+	}
+	if n := sc.SkipWhitespace(); n != 0 {
+		t.Errorf("SkipWhitespace() on a word = %d", n)
+	}
+
+	lex := func(sc *Scanner) Token {
+		if sc.SkipWhitespace() > 0 {
+			return Ignore
+		}
+		return lextest(sc)
+	}
+	commas := WithWhitespace(func(r rune) bool { return r == ',' || IsSpace(r) })
+	p := Init[[]string](ReadString("a, b,,c"), WithLexer(lex), commas)
+	for p.More() {
+		p.Expect(identTk, "word")
+		p.Value = append(p.Value, p.Lit())
+	}
+	if v, err := p.Finish(); !slices.Equal(v, []string{"a", "b", "c"}) || err != nil {
+		t.Errorf("Finish() = %v, %v", v, err)
+	}
+}
+
+func TestLexHeredoc(t *testing.T) {
+	cases := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"<<END\necho 1\n  END\nEND x\nEND\nafter", "<<END\necho 1\n  END\nEND x\nEND", true},
+		{"<<END\r\nline\r\nEND\r\n", "<<END\r\nline\r\nEND", true},
+		{"<<END\nEND", "<<END\nEND", true},
+		{"<<END\nunterminated\nENDING\n", "<<END\nunterminated\nENDING\n", false},
+		{"<<END", "<<END", false},
+	}
+	for _, c := range cases {
+		sc := scanString(c.in)
+		sc.AcceptString("<<END")
+		n, ok := sc.LexHeredoc("END")
+		if got := sc.Cursor(); got != c.want || n != len(c.want)-5 || ok != c.ok {
+			t.Errorf("LexHeredoc(%q) = %d, %t, read %q", c.in, n, ok, got)
+		}
+	}
+}
+
+func TestLexNumber(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"1_000_000;", "1_000_000"},
+		{"0xFF_ff+1", "0xFF_ff"},
+		{"12 3", "12"},
+		{"_1", ""},
+		{"x1", ""},
+	}
+	for _, c := range cases {
+		sc := scanString(c.in)
+		n := sc.LexNumber()
+		if got := sc.Cursor(); got != c.want || n != len(c.want) {
+			t.Errorf("LexNumber(%s) = %d, read %q", c.in, n, got)
+		}
+	}
+}
@@ -2,9 +2,16 @@
 package parsekit
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"iter"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 // Parser implements a recursive descent parser.
@@ -15,11 +22,25 @@ type Parser[T any] struct {
 	next func() (Token, bool)
 	stop func()
 
-	peek bool
-	tok  Token // token lookahead
+	peek    bool
+	tok     Token   // token lookahead
+	done    Token   // last token consumed, if tok is peeked at
+	ahead   []Token // tokens read by PeekAt, after tok
+	ntok    int     // number of tokens read
+	syncing bool    // in error recovery, see [Parser.Synchronize]
+	aborted bool    // no more errors are recorded, see [Parser.abort]
+	pending bool    // abort once the outermost [Parser.Try] returns
+	nerr    int     // number of errors found, including duplicates
+	depth0  int     // stack depth of the outermost traced call, see [WithTrace]
+	trying  int     // nesting of [Parser.Try]
+	pulled  []Token // tokens read since the outermost [Parser.Try]
 
-	Value  T
-	errors error
+	// Value is the result of parsing, built by the parse functions.
+	// When errors occur, Value holds everything parsed before them, and after each synchronisation point:
+	// see [Parser.Partial] to know if it is complete.
+	Value T
+	errs  ParseErrors
+	warns []ParseError
 }
 
 // dedicated type for options in parser – avoid generics in ParserOptions
@@ -27,8 +48,21 @@ type emb struct {
 	sc *Scanner
 	lx Lexer
 
-	syncLit []string
-	verbose bool
+	syncLit  []string
+	syncTk   []rune
+	verbose  bool
+	failfast bool
+	maxerr   int
+	tabw     int
+	names    map[rune]string
+	trivia   bool
+	trace    io.Writer
+	maxtok   int
+	linedir  string
+	space    func(rune) bool
+	newlines bool
+	ctx      context.Context
+	filters  []func(iter.Seq[Token]) iter.Seq[Token]
 }
 
 // ParserOptions specialize the behavior of the parser.
@@ -45,23 +79,168 @@ func WithLexer(lx Lexer) ParserOptions { return func(e *emb) { e.lx = lx } }
 // See [Parser.Synchronize] for full documentation.
 func SynchronizeAt(lits ...string) ParserOptions { return func(c *emb) { c.syncLit = lits } }
 
+// SynchronizeAtToken sets the synchronisation token types for error recovery.
+// It can be combined with [SynchronizeAt]; recovery stops at the first token matching either.
+// See [Parser.Synchronize] for full documentation.
+func SynchronizeAtToken(tk ...rune) ParserOptions { return func(c *emb) { c.syncTk = tk } }
+
+// FailFast stops parsing at the first error, instead of synchronizing.
+// [Parser.Synchronize] still recovers from the error, but the parser then behaves as if the input ended,
+// so the parse functions return quickly, and [Parser.Finish] reports only the first error.
+func FailFast() ParserOptions { return func(e *emb) { e.failfast = true } }
+
+// MaxErrors stops parsing after n errors, instead of synchronizing again.
+// A final "too many errors" error is then added, and the parser behaves as with [FailFast].
+func MaxErrors(n int) ParserOptions { return func(e *emb) { e.maxerr = n } }
+
+// WithTabWidth sets the width of tab stops when reporting columns.
+// By default, a tab counts as a single column.
+func WithTabWidth(n int) ParserOptions { return func(e *emb) { e.tabw = n } }
+
+// MaxTokenLen limits the length of tokens to n bytes, to bound the memory used on untrusted input
+// (e.g. by a missing closing quote, which makes a string run to the end of input).
+// A longer token is an error, and ends parsing.
+// By default, tokens have no maximum length.
+func MaxTokenLen(n int) ParserOptions { return func(e *emb) { e.maxtok = n } }
+
+// LineDirective sets the prefix of line directives, which change the position reported for the next lines,
+// so errors in generated input point to the original source:
+//
+//	#line 42 "orig.dsl"
+//
+// A directive is a line starting with prefix (here "#line"), followed by the line number of the next line,
+// and optionally by the file name, quoted or not; invalid directives are ignored.
+// The lexer still reads the directive: it is typically skipped as a comment, or with [Scanner.SkipLine].
+func LineDirective(prefix string) ParserOptions { return func(e *emb) { e.linedir = prefix } }
+
+// WithWhitespace sets the characters skipped by [Scanner.SkipWhitespace], e.g. to also skip commas:
+//
+//	parsekit.WithWhitespace(func(r rune) bool { return r == ',' || parsekit.IsSpace(r) })
+func WithWhitespace(pred func(rune) bool) ParserOptions { return func(e *emb) { e.space = pred } }
+
+// SignificantNewlines makes newlines tokens of type [NewlineToken], for line-oriented grammars:
+//
+//	for p.More() {
+//		parseEntry(p)
+//		p.Expect(parsekit.NewlineToken, "end of line")
+//	}
+//
+// The newline tokens are emitted by the scanner, before the lexer is called:
+// blank lines are part of the newline token before them, and there is no newline token before the first token.
+// [Scanner.SkipLine] and [Scanner.SkipWhitespace] leave newlines to the scanner.
+func SignificantNewlines() ParserOptions { return func(e *emb) { e.newlines = true } }
+
+// WithTokenFilter adds a middleware between the lexer and the parser.
+// Middlewares are applied in the order they are given, the first one receiving the tokens from the lexer:
+//
+//	parsekit.WithTokenFilter(func(seq iter.Seq[parsekit.Token]) iter.Seq[parsekit.Token] {
+//		return parsekit.Filter(seq, func(tk parsekit.Token) bool { return tk.Type != CommentToken })
+//	})
+func WithTokenFilter(fn func(iter.Seq[Token]) iter.Seq[Token]) ParserOptions {
+	return func(e *emb) { e.filters = append(e.filters, fn) }
+}
+
+// WithTokenNames sets names for token types, used by [Parser.Expect] to report the offending token:
+//
+//	parsekit.WithTokenNames(map[rune]string{NumberToken: "NUMBER"}) // expected "}", got NUMBER ("42") instead
+func WithTokenNames(names map[rune]string) ParserOptions { return func(e *emb) { e.names = names } }
+
+// KeepTrivia keeps the tokens ignored by the lexer (e.g. comments), instead of dropping them.
+// They are retrieved with [Parser.TriviaBefore], e.g. to preserve comments in a formatter.
+func KeepTrivia() ParserOptions { return func(e *emb) { e.trivia = true } }
+
+// WithTrace logs the decisions of the parser to w, to debug grammars:
+// each call to [Parser.Expect], [Parser.Match], [Parser.Skip] and [Parser.Errf] is written with the current token,
+// indented by the depth of the parse function making it.
+func WithTrace(w io.Writer) ParserOptions { return func(e *emb) { e.trace = w } }
+
+// WithContext bounds parsing by ctx.
+// Once ctx is done, the parser stops reading input, and reports ctx.Err() as a parse error.
+// The context is checked every few tokens, including the ones ignored by the lexer, and each time more input is read.
+func WithContext(ctx context.Context) ParserOptions { return func(e *emb) { e.ctx = ctx } }
+
 func Verbose() ParserOptions { return func(e *emb) { e.verbose = true } }
 
 // Init creates a new parser.
 // At least two options must be provided: (1) a reader, and (2) a lexer function.
 // Further options (e.g. [SynchronizeAt])
 func Init[T any](opts ...ParserOptions) *Parser[T] {
-	var p Parser[T]
+	p := new(Parser[T])
+	for _, o := range opts {
+		o(&p.emb)
+	}
+	p.sc.tabw, p.sc.maxtok, p.sc.linedir, p.sc.space = p.tabw, p.maxtok, p.linedir, p.space
+	// the scanner checks the context, so input ignored by the lexer is bounded too
+	p.sc.newlines, p.sc.ctx, p.ctx = p.newlines, p.ctx, nil
+	if p.trivia {
+		p.sc.trivia = make(map[int][]Token)
+	}
+
+	if len(p.filters) == 0 && len(p.sc.fill(1)) == 0 && p.sc.err == nil {
+		// empty input (e.g. an optional configuration file): no need to run the lexer
+		eof := Token{Pos: p.sc.locate(p.sc.off)}
+		p.next, p.stop = func() (Token, bool) { return eof, false }, func() {}
+		return p
+	}
+	p.pipe(p.sc.Tokens(p.lx))
+	return p
+}
+
+// InitWith is like [Init], and sets [Parser.Value] to initial before parsing begins.
+// This saves parse functions from initializing a root value that starts nil, e.g. a map or a pointer:
+//
+//	p := parsekit.InitWith(&Config{Options: make(map[string]string)}, parsekit.ReadFiles(path), parsekit.WithLexer(lex))
+func InitWith[T any](initial T, opts ...ParserOptions) *Parser[T] {
+	p := Init[T](opts...)
+	p.Value = initial
+	return p
+}
+
+// FromTokens creates a new parser reading toks, instead of lexing a source.
+// This is convenient to test parse functions on an exact token stream, or to use another tokenizer.
+//
+// Errors are reported at the position of the tokens.
+// If a source is provided in opts (e.g. with [ReadString]), tokens only need a Pos.Offset:
+// line and column are computed from the source, which is also used for [ParseError.Snippet].
+// Other options apply as in [Init]; the lexer, if any, is not used.
+func FromTokens[T any](toks []Token, opts ...ParserOptions) *Parser[T] {
+	p := new(Parser[T])
 	for _, o := range opts {
 		o(&p.emb)
 	}
 
-	p.next, p.stop = iter.Pull(p.sc.Tokens(p.lx))
+	tokens := slices.Values(toks)
+	if p.sc == nil {
+		p.sc = scanString("")
+	} else {
+		// capture the scanner, not p: a stream referencing the parser keeps it alive, and its finalizer never runs
+		sc := p.sc
+		sc.tabw = p.tabw
+		tokens = Map(tokens, func(tk Token) Token {
+			if !tk.Pos.IsValid() {
+				tk.Pos = sc.Locate(tk.Pos.Offset)
+			}
+			return tk
+		})
+	}
+	p.pipe(tokens)
+	return p
+}
 
-	return &p
+// pipe sets tokens, through the filters, as the input of the parser.
+func (p *Parser[T]) pipe(tokens iter.Seq[Token]) {
+	for _, fn := range p.filters {
+		tokens = fn(tokens)
+	}
+	p.next, p.stop = iter.Pull(tokens)
+	// backstop for parsers abandoned before reaching the end of input, or [Parser.Finish]
+	runtime.SetFinalizer(p, func(p *Parser[T]) { p.stop() })
 }
 
 // Finish returns the value, and error of the parsing.
+// The error, if not nil, is a [ParseErrors] listing all errors found.
+// Finish releases the resources held by the parser, and should always be called,
+// even if a parse function panicked.
 // This make it convenient to use at the bottom of a function:
 //
 //	func ReadConfigFiles() (MyStruct, error) {
@@ -69,28 +248,99 @@ func Init[T any](opts ...ParserOptions) *Parser[T] {
 //	   parseConfig(p)
 //	   return p.Finish()
 //	}
-func (p *Parser[T]) Finish() (T, error) { return p.Value, p.errors }
+func (p *Parser[T]) Finish() (T, error) {
+	p.stop()
+	if len(p.errs) == 0 {
+		return p.Value, nil
+	}
+	return p.Value, p.errs
+}
+
+// Drain discards the rest of the input, and releases the resources held by the parser.
+// After Drain, [Parser.More] returns false; errors in the discarded input are not reported.
+// The remaining input is not lexed, so this is cheap even on large inputs.
+//
+// This documents the intent to ignore what follows, e.g. an opaque body after a header.
+func (p *Parser[T]) Drain() {
+	p.stop()
+	p.ahead = p.ahead[:0]
+	p.tok, p.peek = EOF, false
+}
+
+// Partial reports whether errors were found, and [Parser.Value] may therefore be incomplete.
+// Best-effort parsers use it to accept a partial value along with the errors returned by [Parser.Finish].
+func (p *Parser[T]) Partial() bool { return len(p.errs) > 0 }
 
 // Errf triggers a panic mode with the given formatted error.
-// The position is correctly attached to the error.
+// The position, and the source line it points to, are correctly attached to the error.
 func (p *Parser[T]) Errf(format string, args ...any) {
-	panic(parseError{Position{}, fmt.Sprintf(format, args...)})
+	msg := fmt.Sprintf(format, args...)
+	if p.trace != nil {
+		p.tracef("error %q", msg)
+	}
+	panic(p.diag(msg))
 }
 
-type parseError struct {
-	pos Position
-	msg string
+// WrapErrf is like [Parser.Errf], and also attaches err to the error, for callers to test with [errors.Is]:
+//
+//	if _, known := options[name]; !known {
+//		p.WrapErrf(ErrUnknownOption, "unknown option %s", name)
+//	}
+//
+// err is not part of the message: add it to format if needed.
+func (p *Parser[T]) WrapErrf(err error, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if p.trace != nil {
+		p.tracef("error %q", msg)
+	}
+	pe := p.diag(msg)
+	pe.err = err
+	panic(pe)
 }
 
-// Error implements error.
-func (e parseError) Error() string { return fmt.Sprintf("at %s: %s", e.pos, e.msg) }
+// Warnf records a non-fatal diagnostic at the current position.
+// Parsing continues normally; warnings are available from [Parser.Warnings].
+func (p *Parser[T]) Warnf(format string, args ...any) {
+	pe := p.diag(fmt.Sprintf(format, args...))
+	pe.warn = true
+	p.warns = append(p.warns, pe)
+}
+
+// Warnings returns the warnings recorded with [Parser.Warnf], in order.
+func (p *Parser[T]) Warnings() []ParseError { return p.warns }
+
+// diag returns a diagnostic with msg at the position of the current token.
+func (p *Parser[T]) diag(msg string) ParseError { return p.diagAt(p.tok.Pos, msg) }
+
+// diagAt returns a diagnostic with msg at pos.
+func (p *Parser[T]) diagAt(pos Position, msg string) ParseError {
+	line, start := p.sc.lineText(pos.Offset)
+	return ParseError{
+		pos:  pos,
+		msg:  msg,
+		line: line,
+		lcol: min(max(pos.Offset-start, 0), len(line)),
+	}
+}
+
+// got describes the current token in error messages.
+func (p *Parser[T]) got() string {
+	name, ok := p.names[p.tok.Type]
+	switch {
+	case ok:
+		return fmt.Sprintf("%s (%q)", name, p.tok.Lexeme)
+	case p.tok.Type == 0 && p.tok.Lexeme == "":
+		return "EOF"
+	}
+	return strconv.Quote(p.tok.Lexeme)
+}
 
 // More returns true if input is left in the stream.
 // More does not advance the parser state, so use [Parser.Skip] or [Parser.Expect] to consume a value.
 func (p *Parser[T]) More() bool {
 	p.lnext()
 	p.peek = true
-	return p.tok != EOF
+	return !p.tok.IsEOF()
 }
 
 func prettyrune(r rune) string {
@@ -101,17 +351,146 @@ func prettyrune(r rune) string {
 	}
 }
 
+func prettyrunes(tk []rune) string {
+	s := make([]string, len(tk))
+	for i, r := range tk {
+		s[i] = prettyrune(r)
+	}
+	return strings.Join(s, " ")
+}
+
+// tracef writes a trace line for the current token, see [WithTrace].
+// It is called directly by the traced methods, so the stack depth reflects the one of the parse functions.
+func (p *Parser[T]) tracef(format string, args ...any) {
+	var pcs [256]uintptr
+	depth := runtime.Callers(3, pcs[:])
+	if p.depth0 == 0 || depth < p.depth0 {
+		p.depth0 = depth
+	}
+	fmt.Fprintf(p.trace, "%s%s, at %s %s\n", strings.Repeat("  ", depth-p.depth0), fmt.Sprintf(format, args...), p.got(), p.tok.Pos)
+}
+
 // ErrLit is the literal value set after a failed call to [Parser.Expect]
 const ErrLit = "<error>"
 
 // Expects advances the parser to the next input, making sure it matches the token tk.
 func (p *Parser[T]) Expect(tk rune, msg string) {
+	p.lnext()
+	if p.trace != nil {
+		p.tracef("expect %s: %t", msg, p.tok.Type == tk)
+	}
+	if p.tok.Type == tk {
+		p.peek = false
+		return
+	}
+	p.Errf("expected %s, got %s instead", msg, p.got())
+}
+
+// ExpectFunc advances the parser to the next input, making sure it matches the token tk.
+// If it does not, the error message is the one returned by onErr for the offending token:
+//
+//	p.ExpectFunc(InterfaceToken, func(got parsekit.Token) string {
+//		return fmt.Sprintf("unknown declaration %q, did you mean \"interface\"?", got.Lexeme)
+//	})
+func (p *Parser[T]) ExpectFunc(tk rune, onErr func(got Token) string) {
 	p.lnext()
 	if p.tok.Type == tk {
 		p.peek = false
 		return
 	}
-	p.Errf("expected %s, got %q instead", msg, p.tok)
+	p.Errf("%s", onErr(p.tok))
+}
+
+// ExpectLit advances the parser to the next input, making sure its literal is lit.
+// This captures soft keywords, lexed as generic identifiers:
+//
+//	p.ExpectLit("lease", "lease declaration")
+func (p *Parser[T]) ExpectLit(lit string, msg string) {
+	p.lnext()
+	if p.tok.Lexeme == lit {
+		p.peek = false
+		return
+	}
+	p.Errf("expected %s, got %s instead", msg, p.got())
+}
+
+// ExpectOneOf advances the parser to the next input, making sure it matches one of the tokens tk.
+// The type of the matched token is returned, so callers can switch on it.
+func (p *Parser[T]) ExpectOneOf(msg string, tk ...rune) rune {
+	p.lnext()
+	for _, tk := range tk {
+		if p.tok.Type == tk {
+			p.peek = false
+			return tk
+		}
+	}
+	p.Errf("expected %s, got %s instead", msg, p.got())
+	return 0
+}
+
+// ExpectValue advances the parser to the next input, making sure it matches the token tk,
+// and returns its value.
+// A value not of type V is reported as a parse error, rather than a failed type assertion:
+//
+//	p.Value.FixedAddress = parsekit.ExpectValue[Lease, netip.Addr](p, IPToken, "IP address")
+func ExpectValue[T, V any](p *Parser[T], tk rune, msg string) V {
+	p.Expect(tk, msg)
+	v, ok := p.tok.Value.(V)
+	if !ok {
+		p.Errf("expected %s of type %v, got %T instead", msg, reflect.TypeFor[V](), p.tok.Value)
+	}
+	return v
+}
+
+// Setf sets m[k] to v, and decides what to do if k is already set.
+// By default, a duplicate key is a parse error, reported at the current token, so call Setf right after reading the key or its value:
+//
+//	k := p.Lit()
+//	p.Expect('=', "=")
+//	p.Expect(StringToken, "option value")
+//	parsekit.Setf(p, p.Value.opts, k, p.Val().(string), nil)
+//
+// Otherwise, onDup is called with the key before v replaces the previous value;
+// it can record a warning with [Parser.Warnf], or an error with [Parser.Errf] to keep the previous value.
+func Setf[T any, K comparable, V any](p *Parser[T], m map[K]V, k K, v V, onDup func(K)) {
+	if _, dup := m[k]; dup {
+		if onDup == nil {
+			p.Errf("duplicate key %v", k)
+		}
+		onDup(k)
+	}
+	m[k] = v
+}
+
+// ExpectInt advances the parser to the next input, making sure it is an integer, and returns its value.
+// Integers are the tokens with an int64 value, as returned by [Auto] for int, whatever their type:
+//
+//	p.Value.MaxLeaseTime = time.Duration(p.ExpectInt("lease time")) * time.Second
+func (p *Parser[T]) ExpectInt(msg string) int64 {
+	p.lnext()
+	if v, ok := p.tok.Value.(int64); ok {
+		p.peek = false
+		return v
+	}
+	p.Errf("expected %s, got %s instead", msg, p.got())
+	return 0
+}
+
+// ExpectEOF makes sure the input is fully consumed, so trailing tokens are not silently ignored.
+// It is typically called at the end of the top-level parse function:
+//
+//	p.ExpectEOF("end of configuration")
+//
+// On success, the end of input is not consumed, and [Parser.More] keeps returning false.
+func (p *Parser[T]) ExpectEOF(msg string) {
+	p.lnext()
+	p.peek = true
+	if p.trace != nil {
+		p.tracef("expect %s: %t", msg, p.tok.IsEOF())
+	}
+	if !p.tok.IsEOF() {
+		p.Errf("expected %s, got %s instead", msg, p.got())
+	}
 }
 
 // Match returns true if tk is found at the current parsing point.
@@ -119,6 +498,9 @@ func (p *Parser[T]) Expect(tk rune, msg string) {
 func (p *Parser[T]) Match(tk ...rune) bool {
 	p.lnext()
 	p.peek = true
+	if p.trace != nil {
+		p.tracef("match %s: %t", prettyrunes(tk), slices.Contains(tk, p.tok.Type))
+	}
 	for _, tk := range tk {
 		if p.tok.Type == tk {
 			p.peek = false
@@ -128,8 +510,105 @@ func (p *Parser[T]) Match(tk ...rune) bool {
 	return false
 }
 
+// TryExpect advances the parser if the token tk is found at the current parsing point, and reports whether it did.
+// Unlike [Parser.Expect], a mismatch is not an error: nothing is recorded, and the caller decides what to do,
+// so simple grammars can be parsed without [Parser.Synchronize]:
+//
+//	if !p.TryExpect('=') {
+//		return fmt.Errorf("%s: missing value", p.Pos())
+//	}
+//
+// This is the same as [Parser.Match] with a single token.
+// Errors from the lexer still panic, and are recovered by [Parser.Synchronize] as usual.
+func (p *Parser[T]) TryExpect(tk rune) bool { return p.Match(tk) }
+
+// MatchWhich is like [Parser.Match], and also returns the type of the token matched, so callers can switch on it:
+//
+//	switch op, _ := p.MatchWhich('+', '-'); op {
+//	case '+':
+//		…
+//	}
+//
+// On failure, 0 and false are returned, and no input is consumed.
+func (p *Parser[T]) MatchWhich(tk ...rune) (rune, bool) {
+	p.lnext()
+	p.peek = true
+	for _, tk := range tk {
+		if p.tok.Type == tk {
+			p.peek = false
+			return tk, true
+		}
+	}
+	return 0, false
+}
+
+// MatchLit returns true if a token with one of the literals lits is found at the current parsing point.
+// Like [Parser.Match], it consumes the token on success only.
+// This is convenient for contextual keywords, lexed as generic identifiers:
+//
+//	switch {
+//	case p.MatchLit("interface"):
+//		…
+//	case p.MatchLit("fixed-address"):
+//		…
+//	}
+func (p *Parser[T]) MatchLit(lits ...string) bool {
+	p.lnext()
+	p.peek = true
+	if slices.Contains(lits, p.tok.Lexeme) {
+		p.peek = false
+		return true
+	}
+	return false
+}
+
+// Optional consumes tk if it is found at the current parsing point, and reports whether it did.
+// It behaves like [Parser.Match] with a single token, and reads better for zero-or-one constructs:
+//
+//	p.Expect(IdentToken, "field name")
+//	p.Optional(',') // trailing comma
+func (p *Parser[T]) Optional(tk rune) bool { return p.Match(tk) }
+
+// SepBy parses a non-empty list of elements separated by sep.
+// elem is called once, then again each time sep is found at the current parsing point.
+// A trailing separator is not consumed as part of the list: elem is called after it.
+//
+// Errors in elem propagate to the closest [Parser.Synchronize] as usual.
+func (p *Parser[T]) SepBy(sep rune, elem func()) {
+	elem()
+	for p.Match(sep) {
+		elem()
+	}
+}
+
+// ParseList parses a non-empty list of elements separated by sep, like [Parser.SepBy],
+// and returns the values of the elements:
+//
+//	addrs := parsekit.ParseList(p, ',', parseAddr)
+func ParseList[T, E any](p *Parser[T], sep rune, elem func(*Parser[T]) E) []E {
+	var list []E
+	p.SepBy(sep, func() { list = append(list, elem(p)) })
+	return list
+}
+
+// Repeat calls body until the token until is found at the current parsing point.
+// The closing token is consumed, and reaching the end of input before it is an error.
+func (p *Parser[T]) Repeat(until rune, body func()) {
+	for !p.Match(until) {
+		if !p.More() {
+			p.Errf("expected %s, got end of input", prettyrune(until))
+		}
+		body()
+	}
+}
+
 // Skip throws away the current token
 func (p *Parser[T]) Skip() {
+	if p.trace != nil {
+		p.lnext()
+		p.peek = true
+		p.tracef("skip")
+	}
 	if p.peek {
 		p.peek = false
 		return
@@ -137,20 +616,320 @@ func (p *Parser[T]) Skip() {
 	p.lnext()
 }
 
+// Consume advances the parser to the next input, and returns the whole token, whatever its type:
+//
+//	tk := p.Consume()
+//	node := Literal{Value: tk.Value, Pos: tk.Pos}
+//
+// At the end of input, the EOF token is returned.
+func (p *Parser[T]) Consume() Token {
+	p.lnext()
+	p.peek = false
+	return p.tok
+}
+
+// ctxcheck is the number of tokens read between two checks of the context, see [WithContext].
+const ctxcheck = 64
+
 func (p *Parser[T]) lnext() {
 	if p.peek {
 		return
 	}
 
-	p.tok, _ = p.next()
+	p.done = p.tok
+	p.tok = p.pull()
+	if p.tok.IsEOF() {
+		// the stream is over, release it without waiting for Finish
+		p.stop()
+	}
+	p.ntok++
+	if p.ctx != nil && p.ntok%ctxcheck == 0 && p.ctx.Err() != nil {
+		// no more input will be read, the parse functions unwind on EOF
+		p.stop()
+		p.tok.Value, p.tok.Type = p.ctx.Err(), 0
+		p.ctx = nil
+	}
+	for err := p.tok.Error(); err != nil; err = p.tok.Error() {
+		// lexer errors can point inside the token
+		pos := p.tok.Pos
+		var lerr interface{ Position() Position }
+		if errors.As(err, &lerr) {
+			pos = lerr.Position()
+		}
+
+		pe := p.diagAt(pos, err.Error())
+		pe.err = err
+		if !p.syncing {
+			panic(pe)
+		}
+		// already recovering from an error: record it, and keep looking for a synchronisation point
+		p.record(pe)
+		p.tok = p.pull()
+	}
+}
+
+// record adds pe to the errors found, and aborts parsing if no more errors are allowed.
+// Duplicates are not added, but still count towards [MaxErrors], so recovery loops not making progress end.
+func (p *Parser[T]) record(pe ParseError) {
+	if p.aborted {
+		return
+	}
+	p.nerr++ // counted even if pending, so [Parser.Recover] still skips the offending token
+	if p.pending {
+		return
+	}
+	// recovery landing on the same bad token repeats the same error: only report it once
+	if n := len(p.errs); n == 0 || p.errs[n-1].pos != pe.pos || p.errs[n-1].msg != pe.msg {
+		p.errs = append(p.errs, pe)
+	}
+	switch {
+	case p.failfast:
+		p.abort()
+	case p.maxerr > 0 && p.nerr >= p.maxerr:
+		p.errs = append(p.errs, p.diag("too many errors"))
+		p.abort()
+	}
+}
+
+// abort stops parsing: no more input is read, so parse functions unwind on EOF,
+// and the errors raised while doing so are not recorded.
+// Inside [Parser.Try], the error may still be discarded: parsing stops only if it survives the outermost Try.
+func (p *Parser[T]) abort() {
+	if p.trying > 0 {
+		p.pending = true
+		return
+	}
+	p.Drain()
+	p.aborted = true
+}
+
+// pull returns the next token from the stream, after the lookahead tokens.
+func (p *Parser[T]) pull() Token {
+	var tk Token
+	if len(p.ahead) > 0 {
+		tk = p.ahead[0]
+		n := copy(p.ahead, p.ahead[1:])
+		p.ahead = p.ahead[:n]
+	} else {
+		tk, _ = p.next()
+	}
+	if p.trying > 0 {
+		p.pulled = append(p.pulled, tk)
+	}
+	return tk
+}
+
+// PeekAt returns the n-th next token, without consuming any input.
+// PeekAt(1) is the token [Parser.Match] would test.
+// This is useful in grammars that need more than one token to decide on a production:
+//
+//	if p.PeekAt(1).Type == IdentToken && p.PeekAt(2).Type == ':' {
+//		parseLabel(p)
+//	}
+//
+// Error tokens are returned as is, and only reported once they are consumed.
+// The parser buffers the tokens read ahead, so the memory used is bound by the largest n requested.
+// PeekAt panics if n < 1.
+func (p *Parser[T]) PeekAt(n int) Token {
+	if n < 1 {
+		panic("parsekit: PeekAt with n < 1")
+	}
+	if p.peek {
+		if n == 1 {
+			return p.tok
+		}
+		n--
+	}
+	for len(p.ahead) < n {
+		tk, _ := p.next()
+		p.ahead = append(p.ahead, tk)
+	}
+	return p.ahead[n-1]
 }
 
 func (p *Parser[T]) Lit() string { return p.tok.Lexeme }
 func (p *Parser[T]) Val() any    { return p.tok.Value }
 
+// Mark returns the position of the next token, to be used as the start of a [Parser.Span].
+func (p *Parser[T]) Mark() Position {
+	p.lnext()
+	p.peek = true
+	return p.tok.Pos
+}
+
+// Span returns the extent of the source from start to the end of the last consumed token:
+//
+//	func parseBlock(p *parsekit.Parser[AST]) Block {
+//		start := p.Mark()
+//		// … parse the block
+//		return Block{Span: p.Span(start)}
+//	}
+func (p *Parser[T]) Span(start Position) Span {
+	last := p.tok
+	if p.peek {
+		last = p.done
+	}
+	return Span{Start: start, End: advance(last.Pos, last.Lexeme, p.tabw)}
+}
+
+// Text returns the source between start and end, verbatim.
+// This captures constructs not tokenized further (e.g. an embedded code block), between two marks:
+//
+//	start := p.Mark()
+//	parseBlock(p)
+//	code := p.Text(start, p.Span(start).End)
+//
+// The source must still be held by the scanner: this is always the case with [ReadFile] or [ReadString],
+// but streamed sources only keep a window of the input.
+// If the text is not available, a parse error is reported.
+func (p *Parser[T]) Text(start, end Position) string {
+	txt, ok := p.sc.text(start.Offset, end.Offset)
+	if !ok {
+		p.Errf("source text from %s to %s is not available", start, end)
+	}
+	return txt
+}
+
+// Locate returns the position of the byte at offset off in the source, see [Scanner.Locate].
+func (p *Parser[T]) Locate(off int) Position { return p.sc.Locate(off) }
+
+// TriviaBefore returns the tokens ignored by the lexer between the token at pos and the previous one, in source order.
+// Trivia is only kept with the [KeepTrivia] option; the tokens have no type, only a lexeme and a position:
+//
+//	start := p.Mark()
+//	for _, c := range p.TriviaBefore(start) {
+//		if strings.HasPrefix(c.Lexeme, "#") {
+//			decl.Doc = append(decl.Doc, c.Lexeme)
+//		}
+//	}
+//
+// Ignored white space is also returned, if the lexer reads it as separate tokens.
+func (p *Parser[T]) TriviaBefore(pos Position) []Token { return p.sc.trivia[pos.Offset] }
+
+// Pos returns the position of the current token: the last one consumed, or the one peeked at by [Parser.More] or [Parser.Match].
+// This is useful to record where a construct starts in the AST, for later error reporting.
+func (p *Parser[T]) Pos() Position { return p.tok.Pos }
+
+// Recover runs body, recovering from its errors as [Parser.Synchronize] does.
+// This sets a recovery point inside a loop, so each statement of a list is parsed even if the previous one failed:
+//
+//	for p.More() {
+//		p.Recover(func() {
+//			if p.Match(';') {
+//				return // synchronized on the end of a statement
+//			}
+//			parseStatement(p)
+//		})
+//	}
+//
+// If body fails without consuming any token, the offending token is skipped, so such a loop always progresses.
+func (p *Parser[T]) Recover(body func()) {
+	ntok, nerr := p.ntok, p.nerr
+	defer func() {
+		if p.nerr > nerr && p.ntok == ntok && p.peek {
+			p.Skip()
+		}
+	}()
+	defer p.Synchronize()
+	body()
+}
+
+// ParseEach parses the input as a sequence of independent items, and yields each one as soon as it is parsed.
+// This processes large inputs (e.g. one record per line) without keeping all items in [Parser.Value]:
+//
+//	for rec, err := range parsekit.ParseEach(p, parseRecord) {
+//		if err != nil {
+//			log.Print(err)
+//			continue
+//		}
+//		store(rec)
+//	}
+//	_, err := p.Finish()
+//
+// Errors are recovered from as with [Parser.Recover]: an item failing to parse is yielded with the [ParseErrors] found,
+// and parsing goes on with the next item.
+// The errors are also reported by [Parser.Finish].
+func ParseEach[T any](p *Parser[T], one func(*Parser[T]) T) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for p.More() {
+			var v T
+			var failed ParseErrors // the error recovered from
+			nerr := len(p.errs)
+			p.Recover(func() {
+				defer func() {
+					if err := recover(); err != nil {
+						if pe, ok := err.(ParseError); ok {
+							failed = ParseErrors{pe}
+						}
+						panic(err)
+					}
+				}()
+				v = one(p)
+			})
+
+			var err error
+			switch {
+			case failed == nil:
+			case len(p.errs) > nerr:
+				err = p.errs[nerr:]
+			default:
+				// not recorded: a duplicate (see [Parser.record]), or pending in [Parser.Try]
+				err = failed
+			}
+			if !yield(v, err) {
+				return
+			}
+		}
+	}
+}
+
+// Try runs alt, and restores the parser to its state before it if alt returns false or fails with a parse error.
+// This expresses ordered choice (PEG-style a / b), for alternatives not predicted by the next token:
+//
+//	switch {
+//	case p.Try(func() bool { parseCall(p); return true }):
+//	case p.Try(func() bool { parseAssignment(p); return true }):
+//	default:
+//		p.Errf("expected a call or an assignment")
+//	}
+//
+// All tokens read by alt are read again after a restore, and the errors and warnings it recorded are discarded.
+// [Parser.Value] is not restored: alternatives should only update it once they have succeeded.
+// Try calls nest; tokens are kept in memory until the outermost one returns.
+func (p *Parser[T]) Try(alt func() bool) (ok bool) {
+	mark := len(p.pulled)
+	tok, done, peek, ntok := p.tok, p.done, p.peek, p.ntok
+	nerr, nerrs, nwarns, pending := p.nerr, len(p.errs), len(p.warns), p.pending
+
+	p.trying++
+	defer func() {
+		p.trying--
+		if err := recover(); err != nil {
+			if _, ok := err.(ParseError); !ok {
+				panic(err)
+			}
+		}
+
+		if !ok {
+			p.ahead = slices.Concat(p.pulled[mark:], p.ahead)
+			p.pulled = p.pulled[:mark]
+			p.tok, p.done, p.peek, p.ntok = tok, done, peek, ntok
+			p.nerr, p.errs, p.warns, p.pending = nerr, p.errs[:nerrs], p.warns[:nwarns], pending
+		}
+		if p.trying == 0 {
+			p.pulled = p.pulled[:0]
+			if p.pending {
+				p.pending = false
+				p.abort()
+			}
+		}
+	}()
+	return alt()
+}
+
 // Synchronize handles error recovery in the parsing process:
 // when an error occurs, the parser panics all the way to the [Parser.Synchronize] function.
-// All tokens are thrown until the first of lits is found
+// All tokens are thrown until the first of the synchronisation literals or token types is found
 //
 // Run this in a top-level `defer` statement in at the level of the synchronisation elements.
 func (p *Parser[T]) Synchronize() {
@@ -158,18 +937,21 @@ func (p *Parser[T]) Synchronize() {
 	if err == nil {
 		return
 	}
-	pe, ok := err.(parseError)
+	pe, ok := err.(ParseError)
 	if !ok {
-		panic(pe)
+		panic(err)
 	}
 
-	p.errors = errors.Join(p.errors, pe)
+	p.record(pe)
+	if p.aborted {
+		return // unwinding after the last error recorded
+	}
 
+	p.syncing = true
+	defer func() { p.syncing = false }()
 	for p.More() {
-		for _, slit := range p.syncLit {
-			if p.tok.Lexeme == slit {
-				return
-			}
+		if slices.Contains(p.syncLit, p.tok.Lexeme) || slices.Contains(p.syncTk, p.tok.Type) {
+			return
 		}
 		p.Skip()
 	}
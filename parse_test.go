@@ -0,0 +1,1414 @@
+package parsekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	identTk rune = -1 - iota
+	numberTk
+	stringTk
+)
+
+// lextest is a small lexer for tests: lowercase words, numbers, double-quoted strings, and single-character punctuation.
+func lextest(sc *Scanner) Token {
+	switch r := sc.Advance(); {
+	case r == ' ' || r == '\t' || r == '\n':
+		return Ignore
+	case 'a' <= r && r <= 'z':
+		for 'a' <= sc.Peek() && sc.Peek() <= 'z' {
+			sc.Advance()
+		}
+		return Const(identTk)
+	case '0' <= r && r <= '9':
+		for '0' <= sc.Peek() && sc.Peek() <= '9' {
+			sc.Advance()
+		}
+		return Auto[int](numberTk, sc)
+	case r == '"':
+		for sc.Peek() != '"' && sc.Peek() != utf8.RuneError {
+			sc.Advance()
+		}
+		if sc.Advance() != '"' {
+			return Errorf("unterminated string")
+		}
+		return Auto[string](stringTk, sc)
+	default:
+		return Const(r)
+	}
+}
+
+func initTest(src string, opts ...ParserOptions) *Parser[[]string] {
+	return Init[[]string](append([]ParserOptions{ReadString(src), WithLexer(lextest)}, opts...)...)
+}
+
+func TestExpectOneOf(t *testing.T) {
+	p := initTest("a 1 ;")
+	var got []rune
+	func() {
+		defer p.Synchronize()
+		got = append(got, p.ExpectOneOf("word or number", identTk, numberTk))
+		got = append(got, p.ExpectOneOf("word or number", identTk, numberTk))
+		p.ExpectOneOf("word or number", identTk, numberTk)
+	}()
+
+	if len(got) != 2 || got[0] != identTk || got[1] != numberTk {
+		t.Errorf("matched types = %v", got)
+	}
+	if _, err := p.Finish(); err == nil {
+		t.Error("expected an error on ';'")
+	}
+}
+
+func TestOptional(t *testing.T) {
+	p := initTest("a , b")
+	p.Expect(identTk, "word")
+	if p.Optional(';') || p.Optional(numberTk) {
+		t.Fatal("Optional matched a missing token")
+	}
+	if !p.Optional(',') {
+		t.Fatal("Optional did not match ','")
+	}
+	if p.Optional(',') {
+		t.Fatal("Optional matched ',' twice")
+	}
+	p.Expect(identTk, "word")
+	if p.Lit() != "b" {
+		t.Errorf("Optional advanced on mismatch: at %q", p.Lit())
+	}
+}
+
+func TestSepBy(t *testing.T) {
+	p := initTest("a, b, c;")
+	p.SepBy(',', func() {
+		p.Expect(identTk, "word")
+		p.Value = append(p.Value, p.Lit())
+	})
+	p.Expect(';', "end of list")
+
+	if v, err := p.Finish(); len(v) != 3 || err != nil {
+		t.Errorf("SepBy = %v, %v", v, err)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	p := initTest("{ a b } { c", SynchronizeAt("{"))
+	block := func() {
+		defer p.Synchronize()
+		p.Expect('{', "block")
+		p.Repeat('}', func() {
+			p.Expect(identTk, "word")
+			p.Value = append(p.Value, p.Lit())
+		})
+	}
+	block()
+	block()
+
+	v, err := p.Finish()
+	if len(v) != 3 {
+		t.Errorf("Repeat = %v", v)
+	}
+	if err == nil {
+		t.Error("unterminated block should report an error")
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	p := initTest("a\n  b ;")
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "word")
+		p.Expect(identTk, "word")
+		p.Expect(numberTk, "number")
+	}()
+
+	_, err := p.Finish()
+	if err == nil || !strings.HasPrefix(err.Error(), "at <input>:2:5: expected number") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestTokenColumns(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	sc := scanString("ab cd\nef\n" + long + " g")
+	var got []Position
+	for tk := range sc.Tokens(lextest) {
+		if !tk.IsEOF() {
+			got = append(got, tk.Pos)
+		}
+	}
+
+	want := []struct{ line, col int }{{1, 1}, {1, 4}, {2, 1}, {3, 1}, {3, 202}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Line != w.line || got[i].Column != w.col {
+			t.Errorf("token %d at %d:%d, want %d:%d", i, got[i].Line, got[i].Column, w.line, w.col)
+		}
+	}
+}
+
+func TestTabWidth(t *testing.T) {
+	for _, c := range []struct{ width, col int }{{0, 4}, {1, 4}, {4, 9}, {8, 17}} {
+		p := initTest("\ta\t;", WithTabWidth(c.width))
+		p.Expect(identTk, "word")
+		p.Expect(';', "semicolon")
+		if pos := p.tok.Pos; pos.Column != c.col {
+			t.Errorf("tab width %d: column %d, want %d", c.width, pos.Column, c.col)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	p := initTest("a\n\tb c ;")
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "word")
+		p.Expect(identTk, "word")
+		p.Expect(numberTk, "number")
+	}()
+
+	_, err := p.Finish()
+	var pe ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("error %v is not a ParseError", err)
+	}
+	want := "\tb c ;\n\t  ^"
+	if s := pe.Snippet(); !strings.HasSuffix(s, want) {
+		t.Errorf("snippet = %q, want suffix %q", s, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	p := initTest("a ; 1 ; b", SynchronizeAt(";"))
+	for p.More() {
+		func() {
+			defer p.Synchronize()
+			p.Match(';')
+			p.Expect(identTk, "word")
+		}()
+	}
+
+	_, err := p.Finish()
+	var errs ParseErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error %v is not a ParseErrors", err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+	if pos := errs[0].Position(); pos.Line != 1 || pos.Column != 5 {
+		t.Errorf("error at %s", pos)
+	}
+	if msg := errs[0].Message(); !strings.HasPrefix(msg, "expected word") {
+		t.Errorf("message = %q", msg)
+	}
+	if err.Error() != errs[0].Error() {
+		t.Errorf("single error string = %q, want %q", err.Error(), errs[0].Error())
+	}
+}
+
+func TestWarnf(t *testing.T) {
+	p := initTest("old 1")
+	p.Expect(identTk, "word")
+	if p.Lit() == "old" {
+		p.Warnf("%s is deprecated", p.Lit())
+	}
+	p.Expect(numberTk, "number")
+	p.Value = append(p.Value, "done")
+
+	v, err := p.Finish()
+	if err != nil || len(v) != 1 {
+		t.Errorf("Finish = %v, %v", v, err)
+	}
+	w := p.Warnings()
+	if len(w) != 1 || w[0].Message() != "old is deprecated" || w[0].Position().Column != 1 {
+		t.Errorf("warnings = %v", w)
+	}
+}
+
+func TestReadReader(t *testing.T) {
+	const n = 20_000
+	r, w := io.Pipe()
+	go func() {
+		for range n {
+			io.WriteString(w, "word ")
+		}
+		w.Close()
+	}()
+
+	p := Init[int](ReadReader(r), WithLexer(lextest))
+	for p.More() {
+		p.Expect(identTk, "word")
+		p.Value++
+	}
+	if v, err := p.Finish(); v != n || err != nil {
+		t.Errorf("Finish = %d, %v", v, err)
+	}
+	if c := cap(p.sc.buf); c > 2*readsize {
+		t.Errorf("scanner buffered %d bytes", c)
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	broken := errors.New("broken pipe")
+	r := io.MultiReader(strings.NewReader("a b\nc"), iotest.ErrReader(broken))
+
+	p := Init[[]string](ReadReader(r), WithLexer(lextest))
+	func() {
+		defer p.Synchronize()
+		for p.More() {
+			p.Expect(identTk, "word")
+			p.Value = append(p.Value, p.Lit())
+		}
+	}()
+
+	v, err := p.Finish()
+	if len(v) != 3 {
+		t.Errorf("value = %v", v)
+	}
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("error = %v", err)
+	}
+	if pos := errs[0].Position(); errs[0].Message() != "broken pipe" || pos.Line != 2 || pos.Column != 2 {
+		t.Errorf("error = %v", errs[0])
+	}
+}
+
+func TestReadFiles(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "00-base.conf"), filepath.Join(dir, "10-local.conf")
+	os.WriteFile(first, []byte("a\nb"), 0o644)
+	os.WriteFile(second, []byte("c\n 1"), 0o644)
+
+	p := Init[[]string](ReadFiles(first, second), WithLexer(lextest))
+	func() {
+		defer p.Synchronize()
+		for p.More() {
+			p.Expect(identTk, "word")
+			p.Value = append(p.Value, p.Lit())
+		}
+	}()
+	v, err := p.Finish()
+	if !slices.Equal(v, []string{"a", "b", "c"}) {
+		t.Errorf("words = %v", v)
+	}
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("Finish() = %v", err)
+	}
+	if pos := errs[0].Position(); pos.Filename != second || pos.Line != 2 || pos.Column != 2 {
+		t.Errorf("error at %s, want %s:2:2", pos, second)
+	}
+
+	p = Init[[]string](ReadFiles(first, filepath.Join(dir, "does-not-exist")), WithLexer(lextest))
+	func() {
+		defer p.Synchronize()
+		p.More()
+	}()
+	if _, err := p.Finish(); err == nil || !strings.Contains(err.Error(), "does-not-exist") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestLineDirective(t *testing.T) {
+	lex := func(sc *Scanner) Token {
+		if sc.LexLineComment("#") > 0 {
+			return Ignore
+		}
+		return lextest(sc)
+	}
+	src := "a\n#line 10 \"orig.dsl\"\nb\n c\n#line 3\nd\n#line x\ne"
+	p := initTest(src, WithLexer(lex), LineDirective("#line"), SynchronizeAtToken(identTk))
+	for p.More() {
+		p.Recover(func() { p.Expect(numberTk, "number") })
+	}
+	_, err := p.Finish()
+	var errs ParseErrors
+	errors.As(err, &errs)
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Position().String())
+	}
+	want := []string{"<input>:1:1", "orig.dsl:10:1", "orig.dsl:11:2", "orig.dsl:3:1", "orig.dsl:5:1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("errors at %v, want %v", got, want)
+	}
+}
+
+func TestReadFileError(t *testing.T) {
+	p := Init[[]string](ReadFile("testdata/does-not-exist"), WithLexer(lextest))
+	func() {
+		defer p.Synchronize()
+		p.More()
+	}()
+	if _, err := p.Finish(); err == nil || !strings.Contains(err.Error(), "does-not-exist") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+// endless repeats "word " forever.
+type endless struct{}
+
+func (endless) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = "word "[i%5]
+	}
+	return len(b) / 5 * 5, nil
+}
+
+func TestWithContext(t *testing.T) {
+	t.Run("words", func(t *testing.T) { testContext(t, endless{}, lextest) })
+	// the lexer only returns Ignore
+	t.Run("spaces", func(t *testing.T) { testContext(t, spaces{}, lextest) })
+	skip := func(sc *Scanner) Token {
+		sc.SkipWhitespace()
+		return Ignore
+	}
+	// the lexer does not return
+	t.Run("space run", func(t *testing.T) { testContext(t, spaces{}, skip) })
+}
+
+// spaces repeats " " forever.
+type spaces struct{}
+
+func (spaces) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = ' '
+	}
+	return len(b), nil
+}
+
+func testContext(t *testing.T, r io.Reader, lx Lexer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	p := Init[int](ReadReader(r), WithLexer(lx), WithContext(ctx))
+	func() {
+		defer p.Synchronize()
+		for p.More() {
+			p.Expect(identTk, "word")
+			p.Value++
+		}
+	}()
+
+	_, err := p.Finish()
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Message() != context.DeadlineExceeded.Error() {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	parse := func(p *Parser[[]string], finish bool) {
+		defer func() { recover() }()
+		if finish {
+			defer p.Finish()
+		}
+		p.Expect(numberTk, "number") // no Synchronize: the parse error escapes
+	}
+	parse(initTest("a b c"), true)  // released by Finish
+	parse(initTest(""), false)      // released on end of input
+	parse(initTest("a b c"), false) // released by the finalizer
+	toks := []Token{{Type: identTk, Pos: Position{Offset: 0}}, {Type: identTk, Pos: Position{Offset: 2}}}
+	parse(FromTokens[[]string](toks, ReadString("a b")), false)
+
+	for range 100 {
+		runtime.GC()
+		if runtime.NumGoroutine() <= before {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("goroutines: %d before, %d after", before, runtime.NumGoroutine())
+}
+
+func TestSynchronizeRepanics(t *testing.T) {
+	type boom struct{ code int }
+
+	defer func() {
+		if r := recover(); r != (boom{42}) {
+			t.Errorf("recovered %#v, want boom{42}", r)
+		}
+	}()
+
+	p := initTest("a")
+	defer p.Synchronize()
+	panic(boom{42})
+}
+
+func TestSynchronizeAtToken(t *testing.T) {
+	p := initTest("a 1 2 ; b ; 3 c ;", SynchronizeAtToken(';'))
+	for p.More() {
+		func() {
+			defer p.Synchronize()
+			if p.Match(';') {
+				return // synchronized on the end of a statement
+			}
+			p.Expect(identTk, "word")
+			p.Value = append(p.Value, p.Lit())
+			p.Expect(';', "end of statement")
+		}()
+	}
+
+	v, err := p.Finish()
+	if !slices.Equal(v, []string{"a", "b"}) {
+		t.Errorf("value = %v", v)
+	}
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestLexerError(t *testing.T) {
+	p := initTest("a\n \"unterminated")
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "word")
+		p.Expect(stringTk, "string")
+	}()
+
+	_, err := p.Finish()
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("error = %v", err)
+	}
+	if pos := errs[0].Position(); errs[0].Message() != "unterminated string" || pos.Line != 2 || pos.Column != 2 {
+		t.Errorf("error = %v", errs[0])
+	}
+}
+
+func TestAutoErrorPosition(t *testing.T) {
+	// numbers run to the next space, so malformed literals reach Auto
+	lx := func(sc *Scanner) Token {
+		if sc.Advance() == ' ' {
+			return Ignore
+		}
+		sc.AcceptFunc(func(r rune) bool { return r != ' ' })
+		return Auto[int](numberTk, sc)
+	}
+
+	p := Init[[]int64](ReadString("1 12x 3"), WithLexer(lx))
+	func() {
+		defer p.Synchronize()
+		for p.More() {
+			p.Expect(numberTk, "number")
+			p.Value = append(p.Value, p.Val().(int64))
+		}
+	}()
+
+	v, err := p.Finish()
+	if !slices.Equal(v, []int64{1}) {
+		t.Errorf("value = %v", v)
+	}
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("error = %v", err)
+	}
+	if pos := errs[0].Position(); pos.Column != 3 || !strings.Contains(errs[0].Message(), `"12x"`) {
+		t.Errorf("error = %v", errs[0])
+	}
+}
+
+func TestExpectValue(t *testing.T) {
+	p := initTest(`"str" 12`)
+	func() {
+		defer p.Synchronize()
+		if v := ExpectValue[[]string, string](p, stringTk, "string"); v != "str" {
+			t.Errorf("ExpectValue = %q", v)
+		}
+		ExpectValue[[]string, string](p, numberTk, "number")
+	}()
+
+	_, err := p.Finish()
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Message() != "expected number of type string, got int64 instead" {
+		t.Errorf("error = %v", err)
+	}
+	// interface types are named
+	p = initTest("12")
+	func() {
+		defer p.Synchronize()
+		ExpectValue[[]string, error](p, numberTk, "failure")
+	}()
+	if _, err := p.Finish(); !errors.As(err, &errs) || errs[0].Message() != "expected failure of type error, got int64 instead" {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestPeekAt(t *testing.T) {
+	p := initTest("a : b c")
+	var labels, words []string
+	for p.More() {
+		if p.PeekAt(1).Type == identTk && p.PeekAt(2).Type == ':' {
+			p.Expect(identTk, "label")
+			labels = append(labels, p.Lit())
+			p.Expect(':', "colon")
+			continue
+		}
+		p.Expect(identTk, "word")
+		words = append(words, p.Lit())
+	}
+	if !slices.Equal(labels, []string{"a"}) || !slices.Equal(words, []string{"b", "c"}) {
+		t.Errorf("labels = %v, words = %v", labels, words)
+	}
+
+	p = initTest("a b")
+	if tk := p.PeekAt(3); !tk.IsEOF() {
+		t.Errorf("PeekAt past the end = %v", tk)
+	}
+	p.Expect(identTk, "word")
+	if p.Lit() != "a" || !p.Match(identTk) || p.Lit() != "b" || p.More() {
+		t.Error("PeekAt consumed input")
+	}
+}
+
+func TestMatchLit(t *testing.T) {
+	p := initTest("interface 1")
+	if p.MatchLit("expire", "renew") {
+		t.Fatal("MatchLit matched interface")
+	}
+	if !p.MatchLit("expire", "interface") || p.Lit() != "interface" {
+		t.Fatal("MatchLit did not match interface")
+	}
+	if p.MatchLit("interface") || !p.Match(numberTk) {
+		t.Error("MatchLit consumed input on mismatch")
+	}
+}
+
+func TestExpectLit(t *testing.T) {
+	p := initTest("lease release")
+	func() {
+		defer p.Synchronize()
+		p.ExpectLit("lease", "lease declaration")
+		p.ExpectLit("lease", "lease declaration")
+	}()
+
+	_, err := p.Finish()
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Error() != `at <input>:1:7: expected lease declaration, got "release" instead` {
+		t.Errorf("error = %v", err)
+	}
+	p = initTest("lease")
+	func() {
+		defer p.Synchronize()
+		p.ExpectLit("lease", "lease declaration")
+		p.ExpectLit("lease", "lease declaration")
+	}()
+	if _, err := p.Finish(); !errors.As(err, &errs) || len(errs) != 1 || errs[0].Message() != "expected lease declaration, got EOF instead" {
+		t.Errorf("error at end of input = %v", err)
+	}
+}
+
+func TestPos(t *testing.T) {
+	p := initTest("a\n  b")
+	p.Expect(identTk, "word")
+	if pos := p.Pos(); pos.Line != 1 || pos.Column != 1 {
+		t.Errorf("after Expect: %s", pos)
+	}
+	p.More()
+	if pos := p.Pos(); pos.Line != 2 || pos.Column != 3 {
+		t.Errorf("after More: %s", pos)
+	}
+}
+
+func TestSpan(t *testing.T) {
+	p := initTest("x { ab\n cd } y")
+	p.Expect(identTk, "word")
+
+	start := p.Mark()
+	p.Expect('{', "block")
+	p.Repeat('}', func() { p.Expect(identTk, "word") })
+	p.More() // peeking at y does not extend the span
+	sp := p.Span(start)
+
+	if sp.Start.Offset != 2 || sp.Start.Column != 3 {
+		t.Errorf("start = %+v", sp.Start)
+	}
+	if sp.End.Offset != 12 || sp.End.Line != 2 || sp.End.Column != 6 {
+		t.Errorf("end = %+v", sp.End)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	p := initTest(`header 1 "unterminated`)
+	p.Expect(identTk, "header")
+	p.PeekAt(2)
+	p.Drain()
+	if p.More() {
+		t.Error("input left after Drain")
+	}
+	if _, err := p.Finish(); err != nil {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestPartial(t *testing.T) {
+	p := initTest("a ; 1 ; b", SynchronizeAt(";"))
+	if p.Partial() {
+		t.Error("partial before parsing")
+	}
+	for p.More() {
+		func() {
+			defer p.Synchronize()
+			p.Match(';')
+			p.Expect(identTk, "word")
+			p.Value = append(p.Value, p.Lit())
+		}()
+	}
+	if !p.Partial() || !slices.Equal(p.Value, []string{"a", "b"}) {
+		t.Errorf("partial = %t, value = %v", p.Partial(), p.Value)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	parse := func(opts ...ParserOptions) ([]string, error) {
+		p := initTest("a ; 1 ; b ; 2 ; c", append(opts, SynchronizeAt(";"))...)
+		for p.More() {
+			func() {
+				defer p.Synchronize()
+				p.Match(';')
+				p.Expect(identTk, "word")
+				p.Value = append(p.Value, p.Lit())
+			}()
+		}
+		return p.Finish()
+	}
+
+	v, err := parse()
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || len(v) != 3 {
+		t.Errorf("synchronizing: %v, %v", v, err)
+	}
+
+	v, err = parse(FailFast())
+	if !errors.As(err, &errs) || len(errs) != 1 || !slices.Equal(v, []string{"a"}) {
+		t.Errorf("fail fast: %v, %v", v, err)
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	p := initTest(strings.Repeat("1 ; ", 100), SynchronizeAt(";"), MaxErrors(5))
+	for p.More() {
+		func() {
+			defer p.Synchronize()
+			p.Match(';')
+			p.Expect(identTk, "word")
+		}()
+	}
+
+	_, err := p.Finish()
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 6 {
+		t.Fatalf("error = %v", err)
+	}
+	if errs[4].Position().Column != 17 || errs[5].Message() != "too many errors" {
+		t.Errorf("last errors: %v", errs[4:])
+	}
+}
+
+func TestDuplicateErrors(t *testing.T) {
+	// synchronizing on the bad token itself: each attempt fails the same way
+	p := initTest("1 a", SynchronizeAtToken(numberTk))
+	for range 3 {
+		func() {
+			defer p.Synchronize()
+			p.Optional(';') // peeks at the bad token, so it is not consumed by Expect
+			p.Expect(identTk, "word")
+		}()
+	}
+	_, err := p.Finish()
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Errorf("error = %v", err)
+	}
+
+	// duplicates count towards MaxErrors, ending the loop
+	p = initTest("1 a", SynchronizeAtToken(numberTk), MaxErrors(10))
+	for p.More() {
+		func() {
+			defer p.Synchronize()
+			p.Optional(';') // peeks at the bad token, so it is not consumed by Expect
+			p.Expect(identTk, "word")
+		}()
+	}
+	_, err = p.Finish()
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[1].Message() != "too many errors" {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestParseList(t *testing.T) {
+	p := initTest("1, 2, 3 ;")
+	list := ParseList(p, ',', func(p *Parser[[]string]) int64 {
+		return ExpectValue[[]string, int64](p, numberTk, "number")
+	})
+	if !slices.Equal(list, []int64{1, 2, 3}) || !p.Match(';') {
+		t.Errorf("list = %v", list)
+	}
+}
+
+func TestLexErrorPosition(t *testing.T) {
+	lex := func(sc *Scanner) Token {
+		if sc.Peek() == '"' {
+			if _, err := sc.LexStringStrict(); err != nil {
+				return Errorf("%w", err)
+			}
+			return Auto[string](stringTk, sc)
+		}
+		return lextest(sc)
+	}
+	p := initTest(`a "b\zc"`, WithLexer(lex))
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "word")
+		p.Expect(stringTk, "string")
+	}()
+	_, err := p.Finish()
+	var pe ParseErrors
+	if !errors.As(err, &pe) || len(pe) != 1 {
+		t.Fatalf("Finish() = %v", err)
+	}
+	if pos := pe[0].Position(); pos.Column != 5 || pe[0].Message() != `invalid escape sequence \z` {
+		t.Errorf("error at %v: %s", pos, pe[0].Message())
+	}
+	if want := "a \"b\\zc\"\n    ^"; !strings.HasSuffix(pe[0].Snippet(), want) {
+		t.Errorf("Snippet() = %q, want %q", pe[0].Snippet(), want)
+	}
+}
+
+func TestFromTokens(t *testing.T) {
+	src := "a 1\nb"
+	toks := []Token{
+		TokenAt(identTk, nil, "a", 0),
+		TokenAt(numberTk, int64(1), "1", 2),
+		TokenAt(identTk, nil, "b", 4),
+	}
+
+	p := FromTokens[[]string](toks)
+	p.Expect(identTk, "word")
+	p.Expect(numberTk, "number")
+	p.Expect(identTk, "word")
+	if p.More() {
+		t.Errorf("tokens left after the slice: %v", p.Lit())
+	}
+	if _, err := p.Finish(); err != nil {
+		t.Errorf("Finish() = %v", err)
+	}
+
+	p = FromTokens[[]string](toks, ReadString(src))
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "word")
+		p.Expect(numberTk, "number")
+		p.Expect(numberTk, "number")
+	}()
+	_, err := p.Finish()
+	var pe ParseErrors
+	if !errors.As(err, &pe) || len(pe) != 1 {
+		t.Fatalf("Finish() = %v", err)
+	}
+	if pos := pe[0].Position(); pos.Line != 2 || pos.Column != 1 {
+		t.Errorf("error at %v, want 2:1", pos)
+	}
+}
+
+func TestTokenNames(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		opts []ParserOptions
+		want string
+	}{
+		{"42", nil, `expected word, got "42" instead`},
+		{"42", []ParserOptions{WithTokenNames(map[rune]string{numberTk: "NUMBER"})}, `expected word, got NUMBER ("42") instead`},
+		{"", nil, `expected word, got EOF instead`},
+	} {
+		p := initTest(c.src, c.opts...)
+		func() {
+			defer p.Synchronize()
+			p.Expect(identTk, "word")
+		}()
+		_, err := p.Finish()
+		var errs ParseErrors
+		if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Message() != c.want {
+			t.Errorf("parsing %q: %v, want %s", c.src, err, c.want)
+		}
+	}
+}
+
+func TestExpectFunc(t *testing.T) {
+	p := initTest("a interfaec")
+	func() {
+		defer p.Synchronize()
+		p.ExpectFunc(identTk, func(Token) string { panic("called on match") })
+		p.ExpectFunc(numberTk, func(got Token) string { return fmt.Sprintf("%q is not a number", got.Lexeme) })
+	}()
+	_, err := p.Finish()
+	if err == nil || err.Error() != `at <input>:1:3: "interfaec" is not a number` {
+		t.Errorf("Finish() = %v", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	for _, c := range []struct {
+		src   string
+		guard bool // skip empty statements
+	}{
+		{"a ; 1 2 ; b ;", true},
+		{"a ; ; b ;", false}, // fails on the synchronisation point
+	} {
+		p := initTest(c.src, SynchronizeAt(";"))
+		for p.More() {
+			p.Recover(func() {
+				if c.guard && p.Match(';') {
+					return
+				}
+				p.Expect(identTk, "word")
+				p.Value = append(p.Value, p.Lit())
+				p.Expect(';', "end of statement")
+			})
+		}
+
+		v, err := p.Finish()
+		if !slices.Equal(v, []string{"a", "b"}) {
+			t.Errorf("%s: value = %v", c.src, v)
+		}
+		var errs ParseErrors
+		if !errors.As(err, &errs) || len(errs) != 1 {
+			t.Errorf("%s: Finish() = %v, want 1 error", c.src, err)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	p := initTest("a { b  c } d")
+	p.Expect(identTk, "word")
+	start := p.Mark()
+	p.Expect('{', "block")
+	p.Repeat('}', func() { p.Expect(identTk, "word") })
+	if got := p.Text(start, p.Span(start).End); got != "{ b  c }" {
+		t.Errorf("Text = %q", got)
+	}
+
+	func() {
+		defer p.Synchronize()
+		p.Text(start, Position{Offset: 100})
+	}()
+	if _, err := p.Finish(); err == nil {
+		t.Error("Text past the end of input should report an error")
+	}
+}
+
+func TestEOFPosition(t *testing.T) {
+	p := initTest("{ a\n  b ")
+	func() {
+		defer p.Synchronize()
+		p.Expect('{', "block")
+		p.Repeat('}', func() { p.Expect(identTk, "word") })
+	}()
+	_, err := p.Finish()
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("Finish() = %v", err)
+	}
+	if pos := errs[0].Position(); pos.Line != 2 || pos.Column != 5 || pos.Offset != 8 {
+		t.Errorf("unterminated block reported at %v", pos)
+	}
+}
+
+func TestKeepTrivia(t *testing.T) {
+	lex := func(sc *Scanner) Token {
+		if sc.LexLineComment("#") > 0 {
+			return Ignore
+		}
+		return lextest(sc)
+	}
+	trivia := func(p *Parser[[]string]) []string {
+		var lits []string
+		for _, tk := range p.TriviaBefore(p.Mark()) {
+			lits = append(lits, strings.TrimSpace(tk.Lexeme))
+		}
+		return slices.DeleteFunc(lits, func(s string) bool { return s == "" })
+	}
+
+	src := "# doc\na # more\n# and more\nb\n# trailing"
+	p := initTest(src, WithLexer(lex), KeepTrivia())
+	var got [][]string
+	for p.More() {
+		got = append(got, trivia(p))
+		p.Skip()
+	}
+	got = append(got, trivia(p))
+	want := [][]string{{"# doc"}, {"# more", "# and more"}, {"# trailing"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("trivia = %q, want %q", got, want)
+	}
+
+	p = initTest(src, WithLexer(lex))
+	if p.More(); p.TriviaBefore(p.Mark()) != nil {
+		t.Error("trivia kept by default")
+	}
+}
+
+func TestParseEach(t *testing.T) {
+	p := initTest("a ; b c ; d ;", SynchronizeAt(";"))
+	record := func(p *Parser[[]string]) []string {
+		if p.Match(';') {
+			return nil
+		}
+		p.Expect(identTk, "word")
+		v := []string{p.Lit()}
+		p.Expect(';', "end of record")
+		return v
+	}
+
+	var got []string
+	nerr := 0
+	for v, err := range ParseEach(p, record) {
+		if err != nil {
+			nerr++
+			continue
+		}
+		got = append(got, v...)
+	}
+	if !slices.Equal(got, []string{"a", "d"}) || nerr != 1 {
+		t.Errorf("ParseEach = %v, with %d errors", got, nerr)
+	}
+	if _, err := p.Finish(); err == nil {
+		t.Error("Finish should report the error")
+	}
+
+	// the second item fails on the same token, the error is only recorded once
+	p = initTest("a ; b c", SynchronizeAt(";"))
+	pair := func(p *Parser[[]string]) []string {
+		for range 2 {
+			if !p.Match(identTk) {
+				p.Errf("expected word") // the token is not consumed
+			}
+		}
+		return nil
+	}
+	var errs []string
+	for _, err := range ParseEach(p, pair) {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	want := `at <input>:1:3: expected word`
+	if !slices.Equal(errs, []string{want, want}) {
+		t.Errorf("ParseEach errors = %q", errs)
+	}
+	if _, err := p.Finish(); err == nil || err.Error() != want {
+		t.Errorf("Finish() = %v", err)
+	}
+
+	// errors pending in Try are not recorded yet: each item has its own
+	p = initTest("1 ; a 2", FailFast(), SynchronizeAt(";"))
+	errs = nil
+	p.Try(func() bool {
+		p.Recover(func() { p.Expect(identTk, "word") })
+		for _, err := range ParseEach(p, pair) {
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		return true
+	})
+	if want := []string{`at <input>:1:3: expected word`, `at <input>:1:7: expected word`}; !slices.Equal(errs, want) {
+		t.Errorf("ParseEach errors in Try = %q, want %q", errs, want)
+	}
+}
+
+func TestScannerErrorf(t *testing.T) {
+	// invalid bytes are reported where they are, not at the start of the token
+	p := initTest("a\n~~\x00 b", WithLexer(func(sc *Scanner) Token {
+		if sc.AcceptString("~~") {
+			if r := sc.Advance(); r == 0 {
+				return sc.Errorf("invalid character %U in input", r)
+			}
+		}
+		return lextest(sc)
+	}))
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "word")
+		p.Expect(identTk, "word")
+	}()
+	_, err := p.Finish()
+	if err == nil || err.Error() != "at <input>:2:3: invalid character U+0000 in input" {
+		t.Errorf("Finish() = %v", err)
+	}
+}
+
+func TestEmptyInput(t *testing.T) {
+	for _, opt := range []ParserOptions{ReadString(""), ReadString("\uFEFF"), ReadReader(strings.NewReader(""))} {
+		p := Init[[]string](opt, WithLexer(func(*Scanner) Token { panic("lexer called on empty input") }))
+		if p.More() {
+			t.Errorf("More() on empty input, at %v", p.Lit())
+		}
+		if v, err := p.Finish(); v != nil || err != nil {
+			t.Errorf("Finish() = %v, %v", v, err)
+		}
+	}
+}
+
+func TestConsume(t *testing.T) {
+	p := initTest("a 12")
+	p.More()
+	if tk := p.Consume(); tk.Type != identTk || tk.Lexeme != "a" {
+		t.Errorf("Consume() = %v", tk)
+	}
+	if tk := p.Consume(); tk.Value != int64(12) || tk.Pos.Column != 3 || p.Lit() != "12" {
+		t.Errorf("Consume() = %v", tk)
+	}
+	if tk := p.Consume(); !tk.IsEOF() {
+		t.Errorf("Consume() at the end = %v", tk)
+	}
+}
+
+func TestMatchWhich(t *testing.T) {
+	p := initTest("a + b - c")
+	p.Expect(identTk, "word")
+	var ops []rune
+	for {
+		op, ok := p.MatchWhich('+', '-')
+		if !ok {
+			break
+		}
+		ops = append(ops, op)
+		p.Expect(identTk, "word")
+	}
+	if !slices.Equal(ops, []rune{'+', '-'}) || p.More() {
+		t.Errorf("operators = %q, more input %t", ops, p.More())
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var b strings.Builder
+	p := initTest("a ( 1", WithTrace(&b), WithTokenNames(map[rune]string{identTk: "IDENT"}))
+	group := func() {
+		p.Expect('(', "group")
+		p.Skip()
+	}
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "word")
+		if p.Match('(', '[') {
+			p.Skip()
+		}
+		group()
+	}()
+
+	want := `expect word: true, at IDENT ("a") <input>:1:1
+match '(' '[': true, at "(" <input>:1:3
+skip, at "1" <input>:1:5
+  expect group: false, at EOF <input>:1:6
+    error "expected group, got EOF instead", at EOF <input>:1:6
+`
+	if b.String() != want {
+		t.Errorf("trace:\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestWrapErrf(t *testing.T) {
+	errUnknown := errors.New("unknown option")
+	p := initTest("a b")
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "option")
+		p.WrapErrf(errUnknown, "unknown option %s", p.Lit())
+	}()
+	_, err := p.Finish()
+	if !errors.Is(err, errUnknown) || err.Error() != "at <input>:1:1: unknown option a" {
+		t.Errorf("Finish() = %v, should wrap %v", err, errUnknown)
+	}
+
+	// errors from the lexer are wrapped too
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = initTest(strings.Repeat("a ", 2*ctxcheck), WithContext(ctx))
+	func() {
+		defer p.Synchronize()
+		for p.More() {
+			p.Skip()
+		}
+	}()
+	if _, err := p.Finish(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Finish() = %v, should wrap %v", err, context.Canceled)
+	}
+}
+
+func TestMarshalDiagnostics(t *testing.T) {
+	p := initTest("a\n1")
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "word")
+		p.Warnf("%s is deprecated", p.Lit())
+		p.Expect(identTk, "word")
+	}()
+	_, err := p.Finish()
+
+	out, merr := MarshalDiagnostics(errors.Join(err, ParseErrors(p.Warnings()), io.ErrUnexpectedEOF))
+	want := `[{"file":"","line":2,"column":1,"offset":2,"message":"expected word, got \"1\" instead","severity":"error"},` +
+		`{"file":"","line":1,"column":1,"offset":0,"message":"a is deprecated","severity":"warning"},` +
+		`{"file":"","line":0,"column":0,"offset":0,"message":"unexpected EOF","severity":"error"}]`
+	if merr != nil || string(out) != want {
+		t.Errorf("MarshalDiagnostics = %s, %v\nwant %s", out, merr, want)
+	}
+
+	out, _ = MarshalDiagnostics(fmt.Errorf("load config: %w", err))
+	if want := `[{"file":"","line":2,"column":1,"offset":2,"message":"expected word, got \"1\" instead","severity":"error"}]`; string(out) != want {
+		t.Errorf("MarshalDiagnostics(wrapped) = %s\nwant %s", out, want)
+	}
+	out, _ = MarshalDiagnostics(fmt.Errorf("load config: %w", io.ErrUnexpectedEOF))
+	if want := `[{"file":"","line":0,"column":0,"offset":0,"message":"load config: unexpected EOF","severity":"error"}]`; string(out) != want {
+		t.Errorf("MarshalDiagnostics(wrapped) = %s\nwant %s", out, want)
+	}
+
+	if out, _ := MarshalDiagnostics(nil); string(out) != "[]" {
+		t.Errorf("MarshalDiagnostics(nil) = %s", out)
+	}
+}
+
+func TestMaxTokenLen(t *testing.T) {
+	lex := func(sc *Scanner) Token {
+		if sc.LexString() > 0 {
+			return Auto[string](stringTk, sc)
+		}
+		return lextest(sc)
+	}
+	src := `a "` + strings.Repeat("x", 1<<20)
+	for _, opt := range []ParserOptions{ReadString(src), ReadReader(strings.NewReader(src))} {
+		p := Init[[]string](opt, WithLexer(lex), MaxTokenLen(1000))
+		func() {
+			defer p.Synchronize()
+			for p.More() {
+				p.Skip()
+			}
+		}()
+		_, err := p.Finish()
+		if err == nil || err.Error() != "at <input>:1:3: token longer than 1000 bytes" {
+			t.Errorf("Finish() = %v", err)
+		}
+		if p.sc.rd != nil && cap(p.sc.buf) > 4*readsize {
+			t.Errorf("buffered %d bytes for an overlong token", cap(p.sc.buf))
+		}
+	}
+}
+
+func TestTryExpect(t *testing.T) {
+	p := initTest("a = 1")
+	if !p.TryExpect(identTk) || p.TryExpect(numberTk) || !p.TryExpect('=') || !p.TryExpect(numberTk) {
+		t.Error("TryExpect did not follow the input")
+	}
+	if _, err := p.Finish(); err != nil {
+		t.Errorf("TryExpect recorded an error: %v", err)
+	}
+}
+
+func TestSignificantNewlines(t *testing.T) {
+	lex := func(sc *Scanner) Token {
+		switch {
+		case sc.SkipWhitespace() > 0:
+			return Ignore
+		case sc.LexLineComment("#") > 0:
+			return Ignore
+		}
+		return lextest(sc)
+	}
+	src := "\n[a]\nk = 1 # comment\n\n  \r\n# only a comment\nj = 2\n"
+	p := initTest(src, WithLexer(lex), SignificantNewlines())
+	for p.More() {
+		if p.Match('[') {
+			p.Expect(identTk, "section")
+			p.Value = append(p.Value, p.Lit())
+			p.Expect(']', "end of section")
+		} else {
+			p.Expect(identTk, "key")
+			p.Value = append(p.Value, p.Lit())
+			p.Expect('=', "=")
+			p.Expect(numberTk, "value")
+		}
+		p.Expect(NewlineToken, "end of line")
+	}
+	v, err := p.Finish()
+	if !slices.Equal(v, []string{"a", "k", "j"}) || err != nil {
+		t.Errorf("Finish() = %v, %v", v, err)
+	}
+}
+
+func TestTry(t *testing.T) {
+	// call: a ( b ) ;  assignment: a = 1 ;
+	p := initTest("f ( x ) ; v = 1 ; w ;")
+	call := func() bool {
+		p.Expect(identTk, "function")
+		name := p.Lit()
+		p.Expect('(', "(")
+		p.Expect(identTk, "argument")
+		p.Expect(')', ")")
+		p.Value = append(p.Value, "call "+name)
+		return true
+	}
+	assign := func() bool {
+		p.Expect(identTk, "variable")
+		name := p.Lit()
+		if !p.Match('=') {
+			return false
+		}
+		p.Expect(numberTk, "value")
+		p.Value = append(p.Value, "set "+name)
+		return true
+	}
+	for p.More() {
+		if !p.Try(call) && !p.Try(assign) && !p.Try(func() bool { return p.Try(call) }) {
+			p.Expect(identTk, "statement")
+			p.Value = append(p.Value, "bare "+p.Lit())
+		}
+		p.Expect(';', "end of statement")
+	}
+
+	v, err := p.Finish()
+	if want := []string{"call f", "set v", "bare w"}; !slices.Equal(v, want) || err != nil {
+		t.Errorf("Finish() = %v, %v, want %v", v, err, want)
+	}
+}
+
+func TestExpectInt(t *testing.T) {
+	p := initTest("42 x")
+	var got []int64
+	func() {
+		defer p.Synchronize()
+		got = append(got, p.ExpectInt("count"))
+		got = append(got, p.ExpectInt("count"))
+	}()
+	_, err := p.Finish()
+	if !slices.Equal(got, []int64{42}) || err == nil || err.Error() != `at <input>:1:4: expected count, got "x" instead` {
+		t.Errorf("ExpectInt = %v, %v", got, err)
+	}
+}
+
+func TestExpectEOF(t *testing.T) {
+	p := initTest("a b")
+	func() {
+		defer p.Synchronize()
+		p.Expect(identTk, "name")
+		p.ExpectEOF("end of input")
+	}()
+	if _, err := p.Finish(); err == nil || err.Error() != `at <input>:1:3: expected end of input, got "b" instead` {
+		t.Errorf("ExpectEOF with trailing tokens: %v", err)
+	}
+
+	p = initTest("a")
+	p.Expect(identTk, "name")
+	p.ExpectEOF("end of input")
+	if _, err := p.Finish(); err != nil {
+		t.Errorf("ExpectEOF at end of input: %v", err)
+	}
+}
+
+func TestSetf(t *testing.T) {
+	parse := func(p *Parser[map[string]string], onDup func(string)) {
+		for p.More() {
+			p.Recover(func() {
+				p.Expect(identTk, "key")
+				k := p.Lit()
+				p.Expect(stringTk, "value")
+				Setf(p, p.Value, k, p.Val().(string), onDup)
+			})
+		}
+	}
+
+	p := InitWith(map[string]string{}, ReadString(`a "1" b "2" a "3"`), WithLexer(lextest))
+	parse(p, nil)
+	opts, err := p.Finish()
+	if err == nil || err.Error() != `at <input>:1:15: duplicate key a` || opts["a"] != "1" || opts["b"] != "2" {
+		t.Errorf("Setf = %v, %v", opts, err)
+	}
+
+	p = InitWith(map[string]string{}, ReadString(`a "1" a "2"`), WithLexer(lextest))
+	parse(p, func(k string) { p.Warnf("%s is overridden", k) })
+	opts, err = p.Finish()
+	if err != nil || opts["a"] != "2" || len(p.Warnings()) != 1 {
+		t.Errorf("Setf with override = %v, %v", opts, err)
+	}
+}
+
+func TestInitWith(t *testing.T) {
+	type config struct{ names []string }
+	p := InitWith(&config{}, ReadString("a b"), WithLexer(lextest))
+	for p.More() {
+		p.Expect(identTk, "name")
+		p.Value.names = append(p.Value.names, p.Lit())
+	}
+	if cfg, err := p.Finish(); err != nil || !slices.Equal(cfg.names, []string{"a", "b"}) {
+		t.Errorf("InitWith = %v, %v", cfg, err)
+	}
+}
+
+func TestTryAbort(t *testing.T) {
+	for _, opt := range []ParserOptions{FailFast(), MaxErrors(1)} {
+		// the error is discarded with the alternative: the input is parsed to the end
+		p := initTest("a b c ;", opt, SynchronizeAt(";"))
+		p.Try(func() bool {
+			defer p.Synchronize()
+			p.Expect(numberTk, "number")
+			return true
+		})
+		for p.More() && !p.Match(';') {
+			p.Expect(identTk, "word")
+			p.Value = append(p.Value, p.Lit())
+		}
+		if v, err := p.Finish(); !slices.Equal(v, []string{"a", "b", "c"}) || err != nil {
+			t.Errorf("failed alternative: %v, %v", v, err)
+		}
+
+		// the error survives the alternative: parsing stops after it
+		p = initTest("1 ; a b", opt, SynchronizeAt(";"))
+		p.Try(func() bool {
+			func() {
+				defer p.Synchronize()
+				p.Expect(identTk, "word")
+			}()
+			return true
+		})
+		if p.More() {
+			t.Error("parsing should stop after the error")
+		}
+		if _, err := p.Finish(); err == nil {
+			t.Error("the error should be reported")
+		}
+
+		// errors pending in Try still make Recover progress
+		p = initTest("1 ; a", opt, SynchronizeAt(";"))
+		p.Try(func() bool {
+			for p.More() {
+				p.Recover(func() {
+					if !p.Match(identTk) {
+						p.Errf("expected word") // the token is not consumed
+					}
+				})
+			}
+			return true
+		})
+		if _, err := p.Finish(); err == nil || !strings.HasPrefix(err.Error(), "at <input>:1:1: expected word") {
+			t.Errorf("Recover in Try: %v", err)
+		}
+	}
+}
@@ -1,12 +1,23 @@
 package parsekit
 
 import (
+	"bytes"
+	"context"
 	"encoding"
+	"errors"
 	"fmt"
+	"io"
 	"iter"
+	"maps"
+	"net"
 	"os"
 	"reflect"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
 )
 
@@ -14,7 +25,7 @@ import (
 // A position is valid if Line > 0.
 type Position struct {
 	Filename string // filename, if any
-	Offset   int    // byte offset, starting at 0
+	Offset   int    // byte offset, starting at 0 after the byte order mark, if any
 	Line     int    // line number, starting at 1
 	Column   int    // column number, starting at 1 (character count per line)
 }
@@ -33,13 +44,135 @@ func (pos Position) String() string {
 	return s
 }
 
-// Scanner reads lexemes from a source
+// Span is the extent of a construct in the source.
+// End is the position just past its last byte.
+type Span struct {
+	Start, End Position
+}
+
+// Scanner reads lexemes from a source.
+//
+// The source is buffered in a window: when the scanner reads from an [io.Reader],
+// only the content from the start of the current token is retained, and more is read as lexers need it.
+//
+// A UTF-8 byte order mark at the start of the source is skipped, and not seen by lexers.
 type Scanner struct {
-	src string
+	rd   io.Reader // source of more content, nil once the source is fully read
+	rc   io.Closer // closed with the end of rd, if set
+	buf  []byte    // window over the source
+	base int       // offset of buf[0] in the source
+
+	name     string          // file name reported in positions
+	files    []srcfile       // files concatenated in the source, see [ReadFiles]
+	linedir  string          // prefix of line directives, see [LineDirective]
+	space    func(rune) bool // white space, see [WithWhitespace]
+	newlines bool            // newlines are tokens, see [SignificantNewlines]
+	tabw     int             // tab width for columns, tabs count as one column if <= 1
+	maxtok   int             // maximum length of a token, unlimited if 0
+	ctx      context.Context // stops reading when done, see [WithContext]
+
+	lines   []int // offsets of the newlines in the source, from nlfrom up to nlscan
+	nldrop  int   // number of newlines before nlfrom, only counted
+	nlfrom  int   // offset from which newlines are recorded in lines
+	nlscan  int   // offset up to which lines have been recorded
+	basecol int   // column of the byte at base, if the start of its line has been discarded
+	bomseen bool  // a leading byte order mark was looked for in the source
+
+	start, off int // offsets in the source of the current token, and of the read cursor
+	last       int // size of the last rune read by Advance, 0 if it cannot be backed up
+
+	err  error // error reading the source, reported as the last token
+	used bool  // tokens have been read, see [Scanner.Tokens]
+
+	trivia  map[int][]Token // ignored tokens, by offset of the token they precede; nil if not kept
+	pending []Token         // ignored tokens, since the last token
+}
+
+var errTokensUsed = errors.New("scanner tokens already read")
+
+// srcfile is a file in a source made of several ones, or the part of a source after a line directive.
+type srcfile struct {
+	name string
+	off  int // offset of the file in the source
+	line int // number of lines in the source before the file
+}
+
+// readsize is the minimum amount of data requested from the reader when the window is extended.
+const readsize = 4096
+
+// ScanReader creates a scanner reading from r.
+// The reader is closed when the scanner reaches the end of input.
+func ScanReader(r io.ReadCloser) *Scanner { return &Scanner{rd: r, rc: r} }
+
+// scanString creates a scanner with the whole content of src buffered.
+func scanString(src string) *Scanner { return &Scanner{buf: bytes.TrimPrefix([]byte(src), bom)} }
 
-	start, off int
+// Reset discards the state of the scanner, and starts reading from r.
+// r is not closed by the scanner.
+// The settings of the scanner (tab width, maximum token length, line directives, white space and newlines) are kept.
+//
+// The memory held by the scanner is reused, so scanners can be kept in a [sync.Pool]
+// to parse many inputs of similar sizes without allocations, with [WithScanner]:
+//
+//	sc := pool.Get().(*parsekit.Scanner)
+//	defer pool.Put(sc)
+//	sc.Reset(r)
+//	p := parsekit.Init[Config](parsekit.WithScanner(sc), parsekit.WithLexer(lex))
+func (s *Scanner) Reset(r io.Reader) {
+	*s = Scanner{
+		rd:       r,
+		buf:      s.buf[:0],
+		lines:    s.lines[:0],
+		linedir:  s.linedir,
+		space:    s.space,
+		newlines: s.newlines,
+		tabw:     s.tabw,
+		maxtok:   s.maxtok,
+	}
+}
+
+// Clone returns a copy of the scanner, at the same read position, for speculative lexing:
+// the clone reads the input independently of s, which can be restored from it on failure.
+//
+//	saved := sc.Clone()
+//	if !lexRegexp(sc) {
+//		sc.Restore(saved)
+//	}
+//
+// The clone has a copy of the content buffered by s, so [Scanner.Reset] on either does not affect the other;
+// for sources read in memory, this is the whole input, so clones are meant for occasional speculation, not for every token.
+// For streamed sources, the clone only has the content currently buffered, and ends there;
+// it must not be used to restore s.
+// Trivia is not kept by the clone (see [KeepTrivia]), and its tokens can be read with [Scanner.Tokens] again.
+func (s *Scanner) Clone() *Scanner {
+	c := *s
+	c.buf, c.lines, c.files = bytes.Clone(s.buf), slices.Clone(s.lines), slices.Clone(s.files)
+	c.rd, c.rc = nil, nil
+	c.trivia, c.pending = nil, nil
+	c.used = false
+	return &c
+}
 
-	err error // TODO use this as a way to quickly bail out of parsing
+// Restore sets s back to the read position of saved, a clone of s returned by [Scanner.Clone].
+// The trivia collected by s is kept, as well as the tokens it is reading.
+// saved is copied, so it can restore s again.
+func (s *Scanner) Restore(saved *Scanner) {
+	trivia, pending, used := s.trivia, s.pending, s.used
+	*s = *saved
+	s.buf, s.lines, s.files = bytes.Clone(saved.buf), slices.Clone(saved.lines), slices.Clone(saved.files)
+	s.trivia, s.pending, s.used = trivia, pending, used
+}
+
+// ReadReader streams the content of r to the scanner.
+// Content is read as lexers need it, and discarded once its tokens have been passed to the parser:
+// memory use is bounded by the longest token, not by the size of the input: discarded lines are only counted.
+// By contrast, [ReadFile] and [ReadString] keep the whole input in memory.
+//
+// r is not closed by the scanner.
+func ReadReader(r io.Reader) ParserOptions {
+	return func(p *emb) {
+		p.sc = &Scanner{rd: r}
+	}
 }
 
 // ReadFile reads the content of file name, and passes it to the scanner.
@@ -47,107 +180,807 @@ func ReadFile(name string) ParserOptions {
 	return func(p *emb) {
 		dt, err := os.ReadFile(name)
 		if err != nil {
-			p.sc = &Scanner{err: err}
+			p.sc = &Scanner{name: name, err: err}
 			return
 		}
-		p.sc = &Scanner{src: string(dt)}
+		p.sc = &Scanner{buf: bytes.TrimPrefix(dt, bom), name: name}
+	}
+}
+
+// ReadFiles reads the content of files names, and passes them to the scanner as one source.
+// This parses a document split in several files (e.g. a configuration directory) in one go.
+// Positions report the file name and line in the file, while offsets are in the whole source.
+//
+// A newline is added after files missing one, so tokens do not span files.
+func ReadFiles(names ...string) ParserOptions {
+	return func(p *emb) {
+		sc := &Scanner{}
+		for _, name := range names {
+			dt, err := os.ReadFile(name)
+			if err != nil {
+				p.sc = &Scanner{name: name, err: err}
+				return
+			}
+			sc.files = append(sc.files, srcfile{name: name, off: len(sc.buf), line: bytes.Count(sc.buf, []byte{'\n'})})
+			sc.buf = append(sc.buf, bytes.TrimPrefix(dt, bom)...)
+			if len(sc.buf) > 0 && sc.buf[len(sc.buf)-1] != '\n' {
+				sc.buf = append(sc.buf, '\n')
+			}
+		}
+		p.sc = sc
+	}
+}
+
+// WithScanner passes sc to the parser, to read the input it was created or reset with (see [Scanner.Reset]).
+// The settings of sc are replaced by the ones of the parser options, e.g. [WithTabWidth].
+func WithScanner(sc *Scanner) ParserOptions {
+	return func(p *emb) {
+		p.sc = sc
 	}
 }
 
 // ReadString creates a scanner on src.
 func ReadString(src string) ParserOptions {
 	return func(p *emb) {
-		p.sc = &Scanner{src: src}
+		p.sc = scanString(src)
 	}
 }
 
 // Tokens returns a stream of Tokens from the underlying scanner.
 // The lexer is called repetitively on all yet unread content, and its
 // tokens are returned for consumption in the parser.
+// A lexer can return a token without reading input, but not twice in a row:
+// an error token is then returned, and the next character skipped.
+//
+// With a maximum token length (see [MaxTokenLen]), the input is only read up to the limit:
+// a longer token is reported as an error token, which ends the stream.
+//
+// The stream can only be iterated once, since the scanner consumes its input:
+// later iterations, including from another call to Tokens, yield a single error token.
+// Use [Scanner.Reset] to read tokens from another input.
 func (s *Scanner) Tokens(lx Lexer) iter.Seq[Token] {
 	return func(yield func(Token) bool) {
-		s.start = 0
-		for s.off < len(s.src) {
-			tk := lx(s)
-			if tk != Ignore {
-				tk.Lexeme = s.src[s.start:s.off]
+		if s.used {
+			yield(Token{Value: errTokensUsed, Pos: s.locate(s.off)})
+			return
+		}
+		s.used = true
+
+		s.start = s.off
+		stuck := false  // last call to the lexer did not read any input
+		afternl := true // no token since the last newline token, or the start of input
+		for n := 1; len(s.fill(1)) > 0; n++ {
+			if s.ctx != nil && n%ctxcheck == 0 && s.ctx.Err() != nil {
+				yield(Token{Value: s.ctx.Err(), Pos: s.locate(s.off)})
+				return
+			}
+			var tk Token
+			if s.newlines && s.Peek() == '\n' {
+				s.lexNewlines()
+				if !afternl {
+					tk = Const(NewlineToken)
+				}
+			} else {
+				tk = lx(s)
+			}
+			if s.maxtok > 0 && s.off-s.start > s.maxtok {
+				// the rest of the input cannot be lexed reliably
+				err := s.errorAt(s.start, "token longer than %d bytes", s.maxtok)
+				yield(Token{Value: err, Pos: s.locate(s.start)})
+				return
+			}
+
+			switch {
+			case s.off > s.start:
+				stuck = false
+			case !stuck:
+				stuck = true // allowed once, for tokens synthesized by stateful lexers
+			default:
+				// the lexer is called on the same input again and again: skip it
+				tk = Errorf("lexer made no progress on %q", s.Advance())
+				stuck = false
+			}
+
+			switch {
+			case !tk.IsEOF():
+				tk.Lexeme = s.Cursor()
+				tk.Pos = s.locate(s.start)
+				s.attach(tk.Pos.Offset)
 				if !yield(tk) {
 					return
 				}
+				afternl = tk.Type == NewlineToken
+			case s.trivia != nil && s.off > s.start:
+				// Ignore, kept for [KeepTrivia]
+				s.pending = append(s.pending, Token{Lexeme: s.Cursor(), Pos: s.locate(s.start)})
 			}
 
 			s.start = s.off
+			s.last = 0
+		}
+
+		s.attach(s.off)
+		if s.err != nil {
+			yield(Token{Value: s.err, Pos: s.locate(s.off)})
+			return
 		}
+		yield(Token{Pos: s.locate(s.off)}) // EOF, at the end of input
+	}
+}
+
+// lexNewlines reads a newline, and the blank lines following it.
+func (s *Scanner) lexNewlines() {
+	s.Advance()
+	for {
+		end := s.off
+		s.AcceptRun(" \t\r")
+		if s.Peek() != '\n' {
+			s.off = end // leading space of the next line
+			return
+		}
+		s.Advance()
+	}
+}
 
-		yield(EOF)
+// attach records the pending trivia as found before the token at offset off.
+func (s *Scanner) attach(off int) {
+	if len(s.pending) > 0 {
+		s.trivia[off] = s.pending
+		s.pending = nil
 	}
 }
 
+// window returns the buffered content, from the read cursor.
+func (s *Scanner) window() []byte { return s.buf[s.off-s.base:] }
+
+// fill extends the window until at least n bytes are buffered from the read cursor,
+// or the source is exhausted, and returns the window.
+func (s *Scanner) fill(n int) []byte {
+	for len(s.window()) < n && s.extend() {
+	}
+	return s.window()
+}
+
+// bom is the UTF-8 encoding of the byte order mark.
+var bom = []byte("\uFEFF")
+
+// extend reads more content from the source, and reports if any was added to the window.
+// Content before the start of the current token is discarded.
+func (s *Scanner) extend() bool {
+	if s.maxtok > 0 && s.off-s.start > s.maxtok {
+		return false // reported by [Scanner.Tokens]
+	}
+	if !s.read() {
+		return false
+	}
+	if !s.bomseen {
+		for len(s.buf) < len(bom) && s.read() {
+		}
+		s.bomseen = true
+		s.buf = bytes.TrimPrefix(s.buf, bom)
+		return len(s.buf) > 0 || s.extend()
+	}
+	return true
+}
+
+// read is the implementation of extend, not handling the byte order mark.
+func (s *Scanner) read() bool {
+	if s.rd == nil {
+		return false
+	}
+	if s.ctx != nil && s.ctx.Err() != nil {
+		// reported as a read error, even if the lexer does not return
+		s.err = s.ctx.Err()
+		if s.rc != nil {
+			s.rc.Close()
+		}
+		s.rd, s.rc = nil, nil
+		return false
+	}
+
+	if s.start > s.base {
+		s.scanlines(s.start)
+		s.basecol = s.locate(s.start).Column
+		n := copy(s.buf, s.buf[s.start-s.base:])
+		s.buf = s.buf[:n]
+		s.base = s.start
+		// only count the discarded lines, so memory does not grow with the input
+		if i := sort.SearchInts(s.lines, s.base); i > 1 {
+			s.nldrop += i - 1
+			s.nlfrom = s.lines[i-2] + 1
+			s.lines = s.lines[:copy(s.lines, s.lines[i-1:])]
+		}
+	}
+	if cap(s.buf)-len(s.buf) < readsize {
+		s.buf = slices.Grow(s.buf, max(len(s.buf), readsize))
+	}
+
+	for {
+		n, err := s.rd.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+n]
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
+			if s.rc != nil {
+				s.rc.Close()
+			}
+			s.rd, s.rc = nil, nil
+			return n > 0
+		}
+		if n > 0 {
+			return true
+		}
+	}
+}
+
+// directive records the line directive ending with the newline at offset nl, if there is one.
+// See [LineDirective] for the syntax.
+func (s *Scanner) directive(nl int) {
+	from := 0
+	if len(s.lines) > 0 {
+		from = s.lines[len(s.lines)-1] + 1
+	}
+	if from < s.base {
+		return
+	}
+	txt, ok := bytes.CutPrefix(bytes.TrimSuffix(s.buf[from-s.base:nl-s.base], []byte{'\r'}), []byte(s.linedir))
+	if !ok {
+		return
+	}
+	args := strings.Fields(string(txt))
+	if len(args) == 0 || len(args) > 2 {
+		return
+	}
+	ln, err := strconv.Atoi(args[0])
+	if err != nil || ln < 1 {
+		return
+	}
+
+	if len(s.files) == 0 {
+		s.files = append(s.files, srcfile{name: s.name})
+	}
+	i := sort.Search(len(s.files), func(i int) bool { return s.files[i].off > nl })
+	name := s.files[i-1].name
+	if len(args) == 2 {
+		if name, err = strconv.Unquote(args[1]); err != nil {
+			name = args[1]
+		}
+	}
+	// the next line, after nldrop+len(s.lines)+1 newlines, is line ln
+	s.files = slices.Insert(s.files, i, srcfile{name: name, off: nl + 1, line: s.nldrop + len(s.lines) + 2 - ln})
+}
+
+// scanlines records the newlines in the window up to offset off.
+func (s *Scanner) scanlines(off int) {
+	off = min(off, s.base+len(s.buf))
+	for i := s.nlscan; i < off; i++ {
+		if s.buf[i-s.base] == '\n' {
+			if s.linedir != "" {
+				s.directive(i)
+			}
+			s.lines = append(s.lines, i)
+		}
+	}
+	s.nlscan = max(s.nlscan, off)
+}
+
+// line returns the index of the line holding the byte at offset off (starting at 0),
+// and the offset where this line starts.
+// The newline offsets are recorded once, so each call is logarithmic in the number of lines.
+func (s *Scanner) line(off int) (ln, start int) {
+	s.scanlines(off)
+
+	// number of newlines strictly before off
+	i := sort.SearchInts(s.lines, off)
+	if i > 0 {
+		start = s.lines[i-1] + 1
+	}
+	return s.nldrop + i, start
+}
+
+// lineText returns the content of the line holding the byte at offset off, without the line terminator,
+// and the offset where this line starts.
+// For streamed sources, only the part of the line still in the window is returned.
+func (s *Scanner) lineText(off int) (string, int) {
+	_, start := s.line(off)
+	start = max(start, s.base)
+	lt := s.buf[start-s.base:]
+	if i := bytes.IndexByte(lt, '\n'); i >= 0 {
+		lt = bytes.TrimSuffix(lt[:i], []byte{'\r'})
+	}
+	return string(lt), start
+}
+
+// text returns the source between offsets from and to, if it is still in the window.
+func (s *Scanner) text(from, to int) (string, bool) {
+	if from < s.base || to > s.base+len(s.buf) || from > to {
+		return "", false
+	}
+	return string(s.buf[from-s.base : to-s.base]), true
+}
+
+// Locate returns the position of the byte at offset off in the source.
+//
+// For streamed sources, the position can only be computed for content still in the scanner window;
+// for content already discarded, only the line is reported (Column is 0), if it is recent: older lines are only counted.
+// For content before these, or not read yet, the position is invalid.
+func (s *Scanner) Locate(off int) Position {
+	switch {
+	case off < 0 || off > s.base+len(s.buf):
+		return Position{Filename: s.name, Offset: off}
+	case off < s.nlfrom:
+		return Position{Filename: s.name, Offset: off}
+	case off < s.base:
+		ln, _ := s.line(off)
+		return Position{Filename: s.name, Offset: off, Line: ln + 1}
+	}
+	return s.locate(off)
+}
+
+// locate returns the position of the byte at offset off, which must be in the window.
+func (s *Scanner) locate(off int) Position {
+	ln, start := s.line(off)
+	col := 1
+	if start < s.base {
+		start, col = s.base, s.basecol
+	}
+
+	prefix := s.buf[start-s.base : off-s.base]
+	if off-s.base < len(s.buf) && s.buf[off-s.base] == '\n' {
+		// the line terminator is \r\n: \r does not take a column
+		prefix = bytes.TrimSuffix(prefix, []byte{'\r'})
+	}
+	if s.tabw <= 1 {
+		col += utf8.RuneCount(prefix)
+	} else {
+		for _, r := range string(prefix) {
+			if r == '\t' {
+				col += s.tabw - (col-1)%s.tabw
+			} else {
+				col++
+			}
+		}
+	}
+	pos := Position{Filename: s.name, Offset: off, Line: ln + 1, Column: col}
+	if len(s.files) > 0 {
+		f := s.files[sort.Search(len(s.files), func(i int) bool { return s.files[i].off > off })-1]
+		pos.Filename, pos.Line = f.name, pos.Line-f.line
+	}
+	return pos
+}
+
+// advance returns pos moved past the text txt, using tab stops of width tabw.
+func advance(pos Position, txt string, tabw int) Position {
+	pos.Offset += len(txt)
+	if i := strings.LastIndexByte(txt, '\n'); i >= 0 {
+		pos.Line += strings.Count(txt, "\n")
+		pos.Column = 1
+		txt = txt[i+1:]
+	}
+	for _, r := range txt {
+		if r == '\t' && tabw > 1 {
+			pos.Column += tabw - (pos.Column-1)%tabw
+		} else {
+			pos.Column++
+		}
+	}
+	return pos
+}
+
 // Advances returns the next character in the stream, and increment the read counter.
 func (s *Scanner) Advance() rune {
-	if s.off == len(s.src) {
+	if i := s.off - s.base; i < len(s.buf) && s.buf[i] < utf8.RuneSelf {
+		// fast path for ASCII
+		s.off++
+		s.last = 1
+		return rune(s.buf[i])
+	}
+
+	w := s.fill(utf8.UTFMax)
+	if len(w) == 0 {
+		s.last = 0
 		return utf8.RuneError
 	}
 
-	r, sz := utf8.DecodeRuneInString(s.src[s.off:])
+	r, sz := utf8.DecodeRune(w)
 	s.off += sz
+	s.last = sz
 	return r
 }
 
+// Backup un-reads the last character returned by [Scanner.Advance].
+// Only one character can be backed up: calling Backup again, before any call to Advance,
+// or after Advance reached the end of input is a no-op.
+// Backup never moves before the start of the current token.
+func (s *Scanner) Backup() {
+	s.off -= s.last
+	s.last = 0
+}
+
 // Peek returns the next character in the stream, without incrementing the read counter.
 func (s *Scanner) Peek() rune {
-	if s.off == len(s.src) {
+	if i := s.off - s.base; i < len(s.buf) && s.buf[i] < utf8.RuneSelf {
+		return rune(s.buf[i])
+	}
+
+	w := s.fill(utf8.UTFMax)
+	if len(w) == 0 {
 		return utf8.RuneError
 	}
 
-	r, _ := utf8.DecodeRuneInString(s.src[s.off:])
+	r, _ := utf8.DecodeRune(w)
 	return r
 }
 
+// PeekN returns the n-th next character in the stream, without incrementing the read counter.
+// PeekN(1) is the same as [Scanner.Peek].
+// utf8.RuneError is returned past the end of input, or if n < 1.
+func (s *Scanner) PeekN(n int) rune {
+	if n < 1 {
+		return utf8.RuneError
+	}
+
+	w := s.fill(n * utf8.UTFMax)
+	for ; n > 1 && len(w) > 0; n-- {
+		_, sz := utf8.DecodeRune(w)
+		w = w[sz:]
+	}
+	if len(w) == 0 {
+		return utf8.RuneError
+	}
+
+	r, _ := utf8.DecodeRune(w)
+	return r
+}
+
+// Remaining returns the number of bytes not read yet by the lexer.
+// For sources read in memory ([ReadFile], [ReadString]), this is the exact count, e.g. for a progress bar;
+// for streamed sources, only the content currently buffered is counted.
+func (s *Scanner) Remaining() int { return len(s.window()) }
+
+// ByteAt returns the byte at offset off in the source, and reports whether it is available.
+// This gives random access to the input, e.g. to look back at the previous token.
+//
+// Sources read in memory ([ReadFile], [ReadString]) are always available.
+// For streamed sources, only the scanner window is: the content read so far, from the start of the current token;
+// earlier content is discarded, and the window only extends as the lexer reads further.
+func (s *Scanner) ByteAt(off int) (byte, bool) {
+	if off < s.base || off >= s.base+len(s.buf) {
+		return 0, false
+	}
+	return s.buf[off-s.base], true
+}
+
+// RuneAt returns the character starting at offset off in the source, and reports whether it is available,
+// with the same limits as [Scanner.ByteAt].
+// If off is inside a multi-byte character, or the character is cut by the end of the window, utf8.RuneError is returned.
+func (s *Scanner) RuneAt(off int) (rune, bool) {
+	if off < s.base || off >= s.base+len(s.buf) {
+		return utf8.RuneError, false
+	}
+	r, _ := utf8.DecodeRune(s.buf[off-s.base:])
+	return r, true
+}
+
+// AcceptString advances past lit if the upcoming input matches it, and reports whether it did.
+// The scanner is left untouched if the input does not match.
+//
+//	case r == 'o' && sc.AcceptString("ption"):
+//		return parsekit.Const(OptionKeyword)
+func (s *Scanner) AcceptString(lit string) bool {
+	if !bytes.HasPrefix(s.fill(len(lit)), []byte(lit)) {
+		return false
+	}
+	s.off += len(lit)
+	s.last = 0
+	return true
+}
+
+// AcceptRun advances while the next character is in valid, and returns the number of characters read.
+func (s *Scanner) AcceptRun(valid string) int {
+	return s.AcceptFunc(func(r rune) bool { return strings.ContainsRune(valid, r) })
+}
+
+// AcceptFunc advances while pred returns true for the next character, and returns the number of characters read.
+func (s *Scanner) AcceptFunc(pred func(rune) bool) int {
+	n := 0
+	for len(s.fill(1)) > 0 && pred(s.Peek()) {
+		s.Advance()
+		n++
+	}
+	return n
+}
+
+// SkipLine advances past the next newline, or to the end of input, and returns the number of bytes read.
+// With [SignificantNewlines], the newline itself is not read.
+// This is useful for line comments:
+//
+//	case r == '#':
+//		sc.SkipLine()
+//		return parsekit.Ignore
+func (s *Scanner) SkipLine() int {
+	n := 0
+	for w := s.fill(1); len(w) > 0; w = s.fill(1) {
+		if i := bytes.IndexByte(w, '\n'); i >= 0 {
+			if s.newlines {
+				i-- // the newline is a token
+			}
+			s.off += i + 1
+			n += i + 1
+			break
+		}
+		s.off += len(w)
+		n += len(w)
+	}
+	s.last = 0
+	return n
+}
+
+// AtLineStart reports whether the read cursor is at the start of a line.
+func (s *Scanner) AtLineStart() bool {
+	_, start := s.line(s.off)
+	return start == s.off
+}
+
 // Cursor returns the string currently being scanned
-func (s *Scanner) Cursor() string { return string(s.src[s.start:s.off]) }
+func (s *Scanner) Cursor() string { return string(s.buf[s.start-s.base : s.off-s.base]) }
+
+// Inner returns the string currently being scanned, without its first and last bytes,
+// e.g. the content of a quoted string (escape sequences are left as is).
+// The empty string is returned if less than two bytes were scanned.
+func (s *Scanner) Inner() string {
+	if s.off-s.start < 2 {
+		return ""
+	}
+	return string(s.buf[s.start-s.base+1 : s.off-s.base-1])
+}
+
+// CursorTrim returns the string currently being scanned, without leading and trailing white space.
+func (s *Scanner) CursorTrim() string { return strings.TrimSpace(s.Cursor()) }
+
+// Emit returns a new token of type r, with the value fn returns for the current lexeme.
+// This is a lightweight alternative to [Auto] for trivial conversions:
+//
+//	return sc.Emit(IdentToken, func(s string) any { return strings.ToLower(s) })
+func (s *Scanner) Emit(r rune, fn func(string) any) Token {
+	return Token{Type: r, Value: fn(s.Cursor())}
+}
 
 // EOF is a marker token. The Lexer should return it when [Scanner.Advance] returns an invalid rune.
+// The EOF token passed to the parser is positioned at the end of input, so use [Token.IsEOF] rather than comparing tokens.
 var EOF Token
 
+// NewlineToken is the type of the tokens emitted for newlines, with [SignificantNewlines].
+const NewlineToken = '\n'
+
 // Ignore is a marker token. The Lexer should return it when the current token is to be ignored by the scanner,
 // and not passed to the parser.
 // This is useful to skip over comments, or empty lines.
 var Ignore Token
 
+// Token is a lexeme read by the scanner, with the type and value given by the lexer.
 type Token struct {
 	Type  rune
 	Value any
 
 	Lexeme string
-	Pos    Position
+	Pos    Position // position of the first byte of the lexeme
+}
+
+// End returns the offset just past the lexeme of the token.
+// Together with Pos.Offset, this gives the byte range of the token in the source.
+func (t Token) End() int { return t.Pos.Offset + len(t.Lexeme) }
+
+// GoString implements fmt.GoStringer, to print tokens readably with %#v (e.g. in test failures):
+//
+//	parsekit.Token{Type: '=', Lexeme: "=", Pos: <input>:1:3}
+//	parsekit.Token{Type: -2, Value: int64(12), Lexeme: "12", Pos: <input>:1:5}
+//
+// Fields with their zero value are left out, so the [EOF] marker prints as parsekit.Token{}.
+func (t Token) GoString() string {
+	var fields []string
+	switch {
+	case t.Type > 0 && strconv.IsPrint(t.Type):
+		fields = append(fields, "Type: "+strconv.QuoteRune(t.Type))
+	case t.Type != 0:
+		fields = append(fields, "Type: "+strconv.Itoa(int(t.Type)))
+	}
+	switch v := t.Value.(type) {
+	case nil:
+	case error:
+		fields = append(fields, fmt.Sprintf("Value: error(%q)", v))
+	default:
+		fields = append(fields, fmt.Sprintf("Value: %T(%#v)", v, v))
+	}
+	if t.Lexeme != "" {
+		fields = append(fields, "Lexeme: "+strconv.Quote(t.Lexeme))
+	}
+	if t.Pos != (Position{}) {
+		fields = append(fields, "Pos: "+t.Pos.String())
+	}
+	return "parsekit.Token{" + strings.Join(fields, ", ") + "}"
 }
 
+// IsEOF reports whether t marks the end of input.
+func (t Token) IsEOF() bool { return t.Type == 0 && t.Value == nil }
+
+// Error returns the error carried by an error token, or nil for any other token.
 func (t Token) Error() error {
 	if t.Type != 0 {
 		return nil
 	}
-	return t.Value.(error)
+	err, _ := t.Value.(error)
+	return err
+}
+
+// unquote returns the content of the string s, with escape sequences interpreted if s is in quotes.
+func unquote(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	switch s[0] {
+	case '"', '`':
+		return strconv.Unquote(s)
+	case '\'':
+	default:
+		return s, nil
+	}
+
+	// strconv.Unquote only accepts a single character in single quotes
+	if len(s) < 2 || s[len(s)-1] != '\'' {
+		return "", strconv.ErrSyntax
+	}
+	s = s[1 : len(s)-1]
+	buf := make([]byte, 0, len(s))
+	for len(s) > 0 {
+		c, multibyte, tail, err := strconv.UnquoteChar(s, '\'')
+		if err != nil {
+			return "", err
+		}
+		if c < utf8.RuneSelf || !multibyte {
+			buf = append(buf, byte(c))
+		} else {
+			buf = utf8.AppendRune(buf, c)
+		}
+		s = tail
+	}
+	return string(buf), nil
+}
+
+// AutoTime returns a new token with a [time.Time] value, read from the current lexeme with [time.Parse] and layout.
+// If the lexeme does not match the layout, an error token is returned.
+func AutoTime(r rune, layout string, sc *Scanner) Token {
+	v, err := time.Parse(layout, sc.Cursor())
+	if err != nil {
+		return Token{Value: err}
+	}
+	return Token{Type: r, Value: v}
+}
+
+// AutoEnum returns a new token with the value listed in table for the current lexeme.
+// If the lexeme is not in table, an error token listing the valid values is returned.
+//
+//	var colors = map[string]Color{"red": Red, "green": Green, "blue": Blue}
+//	return parsekit.AutoEnum(ColorToken, colors, sc)
+func AutoEnum[T comparable](r rune, table map[string]T, sc *Scanner) Token {
+	v, ok := table[sc.Cursor()]
+	if !ok {
+		return Errorf("invalid value %q, expected one of %s", sc.Cursor(), strings.Join(slices.Sorted(maps.Keys(table)), ", "))
+	}
+	return Token{Type: r, Value: v}
+}
+
+// Errorf returns an error token, with the given formatted message.
+// Unlike [EOF], the error is reported by the parser at the position of the token, and the lexer is called again after it.
+//
+//	if sc.Peek() == utf8.RuneError {
+//		return parsekit.Errorf("unterminated string")
+//	}
+func Errorf(format string, args ...any) Token { return Token{Value: fmt.Errorf(format, args...)} }
+
+// Errorf returns an error token, with the given formatted message.
+// Unlike the package-level [Errorf], the error is reported at the last character read by [Scanner.Advance],
+// or at the read cursor if it cannot be backed up, rather than at the start of the token:
+//
+//	if r := sc.Advance(); r == 0 {
+//		return sc.Errorf("invalid character %U in input", r)
+//	}
+func (s *Scanner) Errorf(format string, args ...any) Token {
+	return Token{Value: s.errorAt(s.off-s.last, format, args...)}
+}
+
+// lexError is an error found by a lexer, inside the current token.
+// The parser reports it at its position, rather than at the start of the token.
+type lexError struct {
+	pos Position
+	msg string
+}
+
+func (e lexError) Error() string      { return e.msg }
+func (e lexError) Position() Position { return e.pos }
+
+// errorAt returns a lexError at offset off, which must be in the window.
+func (s *Scanner) errorAt(off int, format string, args ...any) error {
+	return lexError{pos: s.locate(off), msg: fmt.Sprintf(format, args...)}
 }
 
 // Const returns a constant token
 func Const(r rune) Token { return Token{Type: r} }
 
+// TokenAt returns a token at byte offset offset in the source, for token streams built outside of a [Lexer].
+// Tokens returned by a lexer get their position from the scanner, and do not need it.
+// See [FromTokens] to compute the line and column.
+func TokenAt(r rune, value any, lexeme string, offset int) Token {
+	return Token{Type: r, Value: value, Lexeme: lexeme, Pos: Position{Offset: offset}}
+}
+
 type Identifier string
 
+var (
+	autolock sync.RWMutex
+	autoconv = map[reflect.Type]func(string) (any, error){
+		// net.IP implements encoding.TextUnmarshaler, but accepts an empty lexeme
+		reflect.TypeFor[net.IP](): func(s string) (any, error) {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: s}
+			}
+			return ip, nil
+		},
+		reflect.TypeFor[net.HardwareAddr](): func(s string) (any, error) { return net.ParseMAC(s) },
+	}
+)
+
+// RegisterAuto records fn as the conversion used by [Auto] for values of type T.
+// This is convenient for types from other packages, which cannot implement [encoding.TextUnmarshaler].
+// Registering a second function for the same type replaces the first one.
+//
+// RegisterAuto is typically called from an init function:
+//
+//	func init() {
+//	   parsekit.RegisterAuto(netip.ParsePrefix)
+//	}
+func RegisterAuto[T any](fn func(lexeme string) (T, error)) {
+	autolock.Lock()
+	defer autolock.Unlock()
+	autoconv[reflect.TypeFor[T]()] = func(s string) (any, error) { return fn(s) }
+}
+
 // Auto returns a new token with value of type T.
 // The value is read from the current lexeme, and converted with:
 //
-//   - strconv.Unquote for strings if the first character is a quote
+//   - strconv.Unquote for strings if the first character is a double quote or a backtick
+//   - Go escape sequences, and \' for strings if the first character is a single quote
 //   - the lexeme directly for strings
-//   - strconv.ParseInt
+//   - strconv.ParseInt for integers, honoring Go base prefixes (0x, 0o, 0b) and digit separators (1_000_000)
+//   - strconv.ParseBool for booleans
+//   - strconv.ParseUint for unsigned integers, with the bit size of T (the value has type T)
 //   - unix and iso times for times
-//   - calling Unmarshaler otherwise
+//   - time.ParseDuration for durations
+//   - net.ParseIP for [net.IP], and net.ParseMAC for [net.HardwareAddr]
+//   - calling Unmarshaler otherwise (this covers [netip.Addr], [netip.Prefix], and [netip.AddrPort])
+//
+// Conversions are looked up in order: functions added with [RegisterAuto] first
+// (the net types are registered by default, and can be replaced),
+// then [encoding.TextUnmarshaler] implementations, then the built-in list above.
 //
-// If the value cannot be parsed, an error token is returned to the parser.
+// If the value cannot be parsed, an error token is returned to the parser,
+// which reports it as a parse error at the position of the lexeme.
 func Auto[T any](r rune, sc *Scanner) Token {
 
 	tt := reflect.TypeFor[T]()
+	autolock.RLock()
+	fn, ok := autoconv[tt]
+	autolock.RUnlock()
+	if ok {
+		v, err := fn(sc.Cursor())
+		if err != nil {
+			return Token{Value: err}
+		}
+		return Token{Type: r, Value: v}
+	}
+
 	{
 		v := reflect.New(tt).Interface()
 		if v, ok := v.(encoding.TextUnmarshaler); ok {
@@ -161,13 +994,32 @@ func Auto[T any](r rune, sc *Scanner) Token {
 
 	switch tt {
 	case reflect.TypeFor[string]():
-		v, err := strconv.Unquote(sc.Cursor())
+		v, err := unquote(sc.Cursor())
 		if err != nil {
 			return Token{Value: err}
 		}
 		return Token{Type: r, Value: v}
 	case reflect.TypeFor[int]():
-		v, err := strconv.ParseInt(sc.Cursor(), 10, 64)
+		v, err := strconv.ParseInt(sc.Cursor(), 0, 64)
+		if err != nil {
+			return Token{Value: err}
+		}
+		return Token{Type: r, Value: v}
+	case reflect.TypeFor[uint](), reflect.TypeFor[uint64](), reflect.TypeFor[uint32](),
+		reflect.TypeFor[uint16](), reflect.TypeFor[uint8]():
+		v, err := strconv.ParseUint(sc.Cursor(), 0, tt.Bits())
+		if err != nil {
+			return Token{Value: err}
+		}
+		return Token{Type: r, Value: reflect.ValueOf(v).Convert(tt).Interface()}
+	case reflect.TypeFor[bool]():
+		v, err := strconv.ParseBool(sc.Cursor())
+		if err != nil {
+			return Token{Value: err}
+		}
+		return Token{Type: r, Value: v}
+	case reflect.TypeFor[time.Duration]():
+		v, err := time.ParseDuration(sc.Cursor())
 		if err != nil {
 			return Token{Value: err}
 		}
@@ -0,0 +1,716 @@
+package parsekit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
+)
+
+// scanned returns a scanner positioned after src, as if a lexer had just read all of it.
+func scanned(src string) *Scanner {
+	s := scanString(src)
+	s.off = len(src)
+	return s
+}
+
+func TestAutoInt(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+	}{
+		{"42", 42},
+		{"-7", -7},
+		{"0xFF", 255},
+		{"0o17", 15},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{"0x_FF_FF", 0xFFFF},
+	}
+	for _, c := range cases {
+		tk := Auto[int](1, scanned(c.in))
+		if tk.Type != 1 || tk.Value != c.want {
+			t.Errorf("Auto[int](%q) = %v, want %d", c.in, tk.Value, c.want)
+		}
+	}
+
+	for _, in := range []string{"12x", "1__000", "1000_", "_1000", "0_x1"} {
+		if tk := Auto[int](1, scanned(in)); tk.Type != 0 {
+			t.Errorf("Auto[int](%s) should return an error token, got %v", in, tk)
+		}
+	}
+}
+
+func TestAutoBool(t *testing.T) {
+	for in, want := range map[string]bool{"true": true, "false": false, "1": true, "0": false, "t": true, "F": false} {
+		tk := Auto[bool](1, scanned(in))
+		if tk.Type != 1 || tk.Value != want {
+			t.Errorf("Auto[bool](%q) = %v, want %t", in, tk.Value, want)
+		}
+	}
+
+	if tk := Auto[bool](1, scanned("yes")); tk.Type != 0 {
+		t.Errorf("Auto[bool](yes) should return an error token, got %v", tk)
+	}
+}
+
+func TestAutoUnsigned(t *testing.T) {
+	if tk := Auto[uint16](1, scanned("8080")); tk.Value != uint16(8080) {
+		t.Errorf("Auto[uint16](8080) = %#v", tk.Value)
+	}
+	if tk := Auto[uint64](1, scanned("0xFFFFFFFFFFFFFFFF")); tk.Value != uint64(1<<64-1) {
+		t.Errorf("Auto[uint64](max) = %#v", tk.Value)
+	}
+	if tk := Auto[uint](1, scanned("0b11")); tk.Value != uint(3) {
+		t.Errorf("Auto[uint](0b11) = %#v", tk.Value)
+	}
+
+	for _, in := range []string{"256", "-1", "x"} {
+		if tk := Auto[uint8](1, scanned(in)); tk.Type != 0 {
+			t.Errorf("Auto[uint8](%q) should return an error token, got %#v", in, tk.Value)
+		}
+	}
+	if tk := Auto[uint32](1, scanned("4294967296")); tk.Type != 0 {
+		t.Errorf("Auto[uint32] should catch overflow, got %#v", tk.Value)
+	}
+}
+
+func TestRegisterAuto(t *testing.T) {
+	type celsius float64
+	RegisterAuto(func(s string) (celsius, error) {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "C"), 64)
+		return celsius(v), err
+	})
+
+	if tk := Auto[celsius](1, scanned("21.5C")); tk.Value != celsius(21.5) {
+		t.Errorf("Auto[celsius](21.5C) = %#v", tk.Value)
+	}
+	if tk := Auto[celsius](1, scanned("hot")); tk.Type != 0 {
+		t.Errorf("Auto[celsius](hot) should return an error token, got %#v", tk.Value)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	s := scanString("aé")
+	s.Backup() // no-op before Advance
+	if s.off != 0 {
+		t.Fatalf("Backup before Advance moved to %d", s.off)
+	}
+
+	s.Advance()
+	if r := s.Advance(); r != 'é' {
+		t.Fatalf("Advance = %q", r)
+	}
+	s.Backup()
+	s.Backup() // only one rune can be backed up
+	if s.off != 1 || s.Peek() != 'é' {
+		t.Errorf("after Backup: off=%d, peek=%q", s.off, s.Peek())
+	}
+
+	s.Advance()
+	s.Advance() // end of input
+	s.Backup()
+	if s.off != len(s.buf) {
+		t.Errorf("Backup after end of input moved to %d", s.off)
+	}
+}
+
+func TestPeekN(t *testing.T) {
+	s := scanString("<é=")
+	for n, want := range []rune{utf8.RuneError, '<', 'é', '=', utf8.RuneError} {
+		if r := s.PeekN(n); r != want {
+			t.Errorf("PeekN(%d) = %q, want %q", n, r, want)
+		}
+	}
+	if s.off != 0 {
+		t.Errorf("PeekN advanced to %d", s.off)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	s := scanString("\uFEFFaé")
+	if n := s.Remaining(); n != 3 {
+		t.Errorf("Remaining() = %d, want 3", n)
+	}
+	s.Advance()
+	s.Advance()
+	if n := s.Remaining(); n != 0 {
+		t.Errorf("Remaining() at the end = %d", n)
+	}
+
+	s = ScanReader(io.NopCloser(strings.NewReader("abc")))
+	if n := s.Remaining(); n != 0 {
+		t.Errorf("Remaining() before reading = %d", n)
+	}
+	s.Advance()
+	if n := s.Remaining(); n != 2 {
+		t.Errorf("Remaining() after reading = %d, want 2", n)
+	}
+}
+
+func TestRuneAt(t *testing.T) {
+	s := scanString("a/é")
+	if b, ok := s.ByteAt(1); b != '/' || !ok {
+		t.Errorf("ByteAt(1) = %q, %t", b, ok)
+	}
+	if r, ok := s.RuneAt(2); r != 'é' || !ok {
+		t.Errorf("RuneAt(2) = %q, %t", r, ok)
+	}
+	if r, ok := s.RuneAt(3); r != utf8.RuneError || !ok {
+		t.Errorf("RuneAt inside a character = %q, %t", r, ok)
+	}
+	for _, off := range []int{-1, 4} {
+		if _, ok := s.ByteAt(off); ok {
+			t.Errorf("ByteAt(%d) is available", off)
+		}
+		if _, ok := s.RuneAt(off); ok {
+			t.Errorf("RuneAt(%d) is available", off)
+		}
+	}
+
+	// streamed: content before the current token is discarded
+	src := strings.Repeat("x", 3*readsize)
+	s = ScanReader(io.NopCloser(strings.NewReader(src)))
+	for range 2 * readsize {
+		s.Advance()
+	}
+	s.start = s.off
+	s.fill(2 * readsize)
+	if _, ok := s.ByteAt(0); ok {
+		t.Error("ByteAt(0) is available after a discard")
+	}
+	if b, ok := s.ByteAt(s.off); b != 'x' || !ok {
+		t.Errorf("ByteAt(off) = %q, %t", b, ok)
+	}
+}
+
+func TestAcceptString(t *testing.T) {
+	s := scanString("option optical")
+	if !s.AcceptString("option") || s.off != 6 {
+		t.Fatalf("AcceptString(option) did not advance: off=%d", s.off)
+	}
+	s.Advance()
+	if s.AcceptString("option") || s.off != 7 {
+		t.Errorf("AcceptString(option) matched %q", s.window())
+	}
+	if s.AcceptString("optical and more") {
+		t.Error("AcceptString matched past end of input")
+	}
+}
+
+func TestAcceptRun(t *testing.T) {
+	s := scanString("123abc")
+	if n := s.AcceptRun("abc"); n != 0 || s.off != 0 {
+		t.Errorf("empty run: n=%d, off=%d", n, s.off)
+	}
+	if n := s.AcceptRun("0123456789"); n != 3 || s.Cursor() != "123" {
+		t.Errorf("digit run: n=%d, cursor=%q", n, s.Cursor())
+	}
+	if n := s.AcceptFunc(func(rune) bool { return true }); n != 3 || s.off != len(s.buf) {
+		t.Errorf("run to end of input: n=%d, off=%d", n, s.off)
+	}
+	if n := s.AcceptFunc(func(rune) bool { return true }); n != 0 {
+		t.Errorf("run at end of input: n=%d", n)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	s := scanString("ab\ncd\n\nef")
+	s.name = "test.conf"
+	cases := []struct{ off, line, col int }{
+		{0, 1, 1}, {1, 1, 2}, {2, 1, 3},
+		{3, 2, 1}, {4, 2, 2},
+		{6, 3, 1},
+		{7, 4, 1}, {8, 4, 2}, {9, 4, 3},
+	}
+	for _, c := range cases {
+		want := Position{Filename: "test.conf", Offset: c.off, Line: c.line, Column: c.col}
+		if got := s.locate(c.off); got != want {
+			t.Errorf("locate(%d) = %+v, want %+v", c.off, got, want)
+		}
+	}
+}
+
+func BenchmarkLocate(b *testing.B) {
+	src := strings.Repeat("option domain-name \"example.org\";\n", 1<<17) // 4.5 MB
+	s := scanString(src)
+	s.locate(0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.locate(i * 7919 % len(src))
+	}
+}
+
+func TestLocateMultibyte(t *testing.T) {
+	src := "\"café\" = 1\n🐟🐟 = 2"
+	s := scanString(src)
+	cases := []struct {
+		at        string
+		line, col int
+	}{
+		{"= 1", 1, 8},
+		{"= 2", 2, 4},
+	}
+	for _, c := range cases {
+		off := strings.Index(src, c.at)
+		if pos := s.locate(off); pos.Line != c.line || pos.Column != c.col || pos.Offset != off {
+			t.Errorf("locate(%q) = %+v, want %d:%d", c.at, pos, c.line, c.col)
+		}
+	}
+}
+
+func TestScanReader(t *testing.T) {
+	src := "ab cd\nef " + strings.Repeat("x", 3*readsize) + "\n  g"
+	want := scanTokens(scanString(src))
+
+	// one byte at a time, so every read needs to extend the window
+	sc := ScanReader(io.NopCloser(iotest.OneByteReader(strings.NewReader(src))))
+	got := scanTokens(sc)
+	if !slices.Equal(got, want) {
+		t.Errorf("streamed tokens differ:\n got %v\nwant %v", got, want)
+	}
+	if len(sc.buf) > 2*readsize {
+		t.Errorf("streamed window kept %d bytes", len(sc.buf))
+	}
+
+	// discarded lines are only counted
+	src = strings.Repeat("ab\n", 10*readsize) + "#line 10 x.conf\n\tc"
+	mem := scanString(src)
+	mem.linedir = "#line"
+	want = scanTokens(mem)
+	sc = ScanReader(io.NopCloser(strings.NewReader(src)))
+	sc.linedir = "#line"
+	if got := scanTokens(sc); !slices.Equal(got, want) {
+		t.Errorf("streamed lines differ: got %v, want %v", got[len(got)-2:], want[len(want)-2:])
+	}
+	if len(sc.lines) > readsize {
+		t.Errorf("streamed scanner kept %d lines", len(sc.lines))
+	}
+}
+
+func TestClone(t *testing.T) {
+	s := scanString("ab\ncd")
+	s.Advance()
+	c := s.Clone()
+	s.AcceptRun("bcd\n")
+	if r := c.Advance(); r != 'b' || c.Cursor() != "ab" {
+		t.Errorf("clone read %q, cursor %q", r, c.Cursor())
+	}
+	s.Restore(c)
+	if r := s.Advance(); r != '\n' || s.locate(s.off).Line != 2 {
+		t.Errorf("restored scanner read %q", r)
+	}
+
+	// the scanners do not share storage: Reset on either does not affect the other
+	src := "ab\ncd\nef" + strings.Repeat("z", readsize)
+	for _, reset := range []string{"scanner", "clone"} {
+		s = scanString(src)
+		s.Locate(len(src) - 1) // record the lines
+		c = s.Clone()
+		other, kept := c, s
+		if reset == "scanner" {
+			other, kept = s, c
+		}
+		other.Reset(strings.NewReader(strings.Repeat("Z\n", readsize)))
+		other.AcceptFunc(func(rune) bool { return true })
+		other.Locate(other.off)
+		if r, pos := kept.Peek(), kept.Locate(7); r != 'a' || pos.Line != 3 || pos.Column != 2 {
+			t.Errorf("after Reset of the %s: Peek = %q, Locate(7) = %d:%d", reset, r, pos.Line, pos.Column)
+		}
+	}
+
+	// trivia is kept across restores
+	lex := func(sc *Scanner) Token {
+		saved := sc.Clone()
+		sc.Advance()
+		sc.Restore(saved)
+		if sc.LexLineComment("#") > 0 {
+			return Ignore
+		}
+		return lextest(sc)
+	}
+	p := initTest("# doc\na # more\nb", WithLexer(lex), KeepTrivia())
+	var ntrivia int
+	for p.More() {
+		for _, tk := range p.TriviaBefore(p.Mark()) {
+			if strings.HasPrefix(tk.Lexeme, "#") {
+				ntrivia++
+			}
+		}
+		p.Skip()
+	}
+	if ntrivia != 2 {
+		t.Errorf("trivia after restores: %d tokens, want 2", ntrivia)
+	}
+
+	// streamed: the clone ends with the buffered content
+	src = strings.Repeat("x", 2*readsize)
+	s = ScanReader(io.NopCloser(strings.NewReader(src)))
+	s.Advance()
+	c = s.Clone()
+	n := c.AcceptFunc(func(rune) bool { return true })
+	if s.AcceptFunc(func(rune) bool { return true }) != len(src)-1 || n >= len(src)-1 {
+		t.Errorf("streamed clone read %d bytes", n)
+	}
+}
+
+func TestTokensOnce(t *testing.T) {
+	sc := scanString("a b")
+	seq := sc.Tokens(lextest)
+	for range seq {
+		break
+	}
+	for _, seq := range []iter.Seq[Token]{seq, sc.Tokens(lextest)} {
+		toks := slices.Collect(seq)
+		if len(toks) != 1 || toks[0].Error() == nil {
+			t.Errorf("second iteration = %v, want an error token", toks)
+		}
+	}
+
+	sc.Reset(strings.NewReader("c"))
+	if toks := scanTokens(sc); len(toks) != 2 || toks[0].Lexeme != "c" {
+		t.Errorf("after Reset = %v", toks)
+	}
+}
+
+func TestTokensNoProgress(t *testing.T) {
+	lex := func(sc *Scanner) Token {
+		if sc.Peek() == '!' {
+			return Ignore // bug: does not advance
+		}
+		return lextest(sc)
+	}
+	done := make(chan []Token)
+	go func() { done <- slices.Collect(scanString("a !b").Tokens(lex)) }()
+
+	select {
+	case toks := <-done:
+		if len(toks) != 4 || toks[1].Error() == nil || toks[1].Pos.Column != 3 || toks[2].Lexeme != "b" {
+			t.Errorf("tokens = %v", toks)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tokens did not return on a lexer making no progress")
+	}
+}
+
+func scanTokens(sc *Scanner) []Token {
+	var toks []Token
+	for tk := range sc.Tokens(lextest) {
+		toks = append(toks, tk)
+	}
+	return toks
+}
+
+func TestSkipLine(t *testing.T) {
+	src := "# comment\n  x # more" + strings.Repeat(".", 2*readsize)
+	for _, sc := range []*Scanner{
+		scanString(src),
+		ScanReader(io.NopCloser(iotest.HalfReader(strings.NewReader(src)))),
+	} {
+		if !sc.AtLineStart() {
+			t.Error("not at line start at offset 0")
+		}
+		if n := sc.SkipLine(); n != 10 || !sc.AtLineStart() {
+			t.Errorf("SkipLine = %d, at line start %t", n, sc.AtLineStart())
+		}
+		sc.Advance()
+		if sc.AtLineStart() {
+			t.Error("at line start after a space")
+		}
+		if n := sc.SkipLine(); n != len(src)-11 || sc.Peek() != utf8.RuneError {
+			t.Errorf("SkipLine to end of input = %d", n)
+		}
+		if n := sc.SkipLine(); n != 0 {
+			t.Errorf("SkipLine at end of input = %d", n)
+		}
+	}
+}
+
+func TestAutoTime(t *testing.T) {
+	tk := AutoTime(1, time.DateTime, scanned("2023-11-03 11:27:26"))
+	if want := time.Date(2023, 11, 3, 11, 27, 26, 0, time.UTC); tk.Value != want {
+		t.Errorf("AutoTime = %v", tk.Value)
+	}
+	if tk := AutoTime(1, time.DateTime, scanned("2023/11/03 11:27:26")); tk.Type != 0 {
+		t.Errorf("AutoTime on mismatched layout should return an error token, got %v", tk.Value)
+	}
+}
+
+func TestAutoEnum(t *testing.T) {
+	type color int
+	colors := map[string]color{"red": 1, "green": 2, "blue": 3}
+	if tk := AutoEnum(1, colors, scanned("green")); tk.Type != 1 || tk.Value != color(2) {
+		t.Errorf("AutoEnum(green) = %#v", tk.Value)
+	}
+	tk := AutoEnum(1, colors, scanned("pink"))
+	if err := tk.Error(); err == nil || err.Error() != `invalid value "pink", expected one of blue, green, red` {
+		t.Errorf("AutoEnum(pink) = %#v", tk.Value)
+	}
+}
+
+func TestAutoDuration(t *testing.T) {
+	if tk := Auto[time.Duration](1, scanned("2h30m")); tk.Value != 150*time.Minute {
+		t.Errorf("Auto[time.Duration](2h30m) = %v", tk.Value)
+	}
+	if tk := Auto[time.Duration](1, scanned("30")); tk.Type != 0 {
+		t.Errorf("Auto[time.Duration](30) should return an error token, got %v", tk.Value)
+	}
+}
+
+func TestAutoNetip(t *testing.T) {
+	if tk := Auto[netip.Prefix](1, scanned("10.67.21.0/24")); tk.Value != netip.MustParsePrefix("10.67.21.0/24") {
+		t.Errorf("Auto[netip.Prefix] = %v", tk.Value)
+	}
+	if tk := Auto[netip.AddrPort](1, scanned("[::1]:8080")); tk.Value != netip.MustParseAddrPort("[::1]:8080") {
+		t.Errorf("Auto[netip.AddrPort] = %v", tk.Value)
+	}
+	for _, in := range []string{"10.67.21.0/33", "10.67.21.0"} {
+		if tk := Auto[netip.Prefix](1, scanned(in)); tk.Type != 0 {
+			t.Errorf("Auto[netip.Prefix](%q) should return an error token, got %v", in, tk.Value)
+		}
+	}
+	if tk := Auto[netip.AddrPort](1, scanned("::1:8080")); tk.Type != 0 {
+		t.Errorf("Auto[netip.AddrPort](::1:8080) should return an error token, got %v", tk.Value)
+	}
+}
+
+func TestReset(t *testing.T) {
+	sc := ScanReader(io.NopCloser(strings.NewReader("a b\nc")))
+	scanTokens(sc)
+
+	src := "d\ne f"
+	sc.Reset(strings.NewReader(src))
+	got := scanTokens(sc)
+	if want := scanTokens(scanString(src)); !slices.Equal(got, want) {
+		t.Errorf("tokens after Reset:\n got %v\nwant %v", got, want)
+	}
+
+	r := strings.NewReader(src)
+	allocs := testing.AllocsPerRun(100, func() {
+		sc.Reset(r)
+		r.Reset(src)
+		for sc.Advance() != utf8.RuneError {
+		}
+		sc.locate(sc.off)
+	})
+	if allocs > 0 {
+		t.Errorf("Reset allocates %.0f times per scan", allocs)
+	}
+
+	// settings are kept
+	sc.maxtok, sc.newlines = 2, true
+	sc.Reset(r)
+	if sc.maxtok != 2 || !sc.newlines {
+		t.Errorf("settings after Reset: maxtok %d, newlines %t", sc.maxtok, sc.newlines)
+	}
+
+	// scanners are reused by parsers
+	for _, src := range []string{"a b", "c"} {
+		sc.Reset(strings.NewReader(src))
+		p := Init[[]string](WithScanner(sc), WithLexer(lextest))
+		for p.More() {
+			p.Expect(identTk, "word")
+			p.Value = append(p.Value, p.Lit())
+		}
+		if v, err := p.Finish(); strings.Join(v, " ") != src || err != nil {
+			t.Errorf("parsing %q with a reset scanner = %v, %v", src, v, err)
+		}
+	}
+}
+
+func BenchmarkAdvance(b *testing.B) {
+	src := strings.Repeat("option domain-name \"example.org\";\n", 1<<15) // 1.1 MB
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		s := scanString(src)
+		for s.Peek() != utf8.RuneError {
+			s.Advance()
+		}
+	}
+}
+
+func TestTokenEnd(t *testing.T) {
+	src := "ab  \"cdé\""
+	for _, tk := range scanTokens(scanString(src)) {
+		if tk.IsEOF() {
+			continue
+		}
+		if txt := src[tk.Pos.Offset:tk.End()]; txt != tk.Lexeme {
+			t.Errorf("token range = %q, want %q", txt, tk.Lexeme)
+		}
+	}
+}
+
+func TestLocatePublic(t *testing.T) {
+	src := "ab\n" + strings.Repeat("x", 3*readsize) + "\ncd"
+	sc := scanString(src)
+	if pos := sc.Locate(len(src) - 1); pos.Line != 3 || pos.Column != 2 {
+		t.Errorf("Locate at end = %+v", pos)
+	}
+	if pos := sc.Locate(len(src) + 1); pos.IsValid() {
+		t.Errorf("Locate past the end = %+v", pos)
+	}
+
+	sc = ScanReader(io.NopCloser(strings.NewReader(src)))
+	scanTokens(sc)
+	if pos := sc.Locate(5); pos.Line != 2 || pos.Column != 0 {
+		t.Errorf("Locate in discarded content = %+v", pos)
+	}
+	if pos := sc.Locate(1); pos.IsValid() {
+		t.Errorf("Locate in discarded lines = %+v", pos)
+	}
+	if pos := sc.Locate(len(src) - 1); pos.Line != 3 || pos.Column != 2 {
+		t.Errorf("Locate in window = %+v", pos)
+	}
+}
+
+func TestLocateCRLF(t *testing.T) {
+	src := "ab\r\ncd ef\r\n\r\ngh"
+	sc := scanString(src)
+	cases := []struct {
+		at        string
+		line, col int
+	}{
+		{"cd", 2, 1}, {"ef", 2, 4}, {"\r\n\r\ngh", 2, 6}, {"\r\ngh", 3, 1}, {"gh", 4, 1},
+	}
+	for _, c := range cases {
+		if pos := sc.Locate(strings.Index(src, c.at)); pos.Line != c.line || pos.Column != c.col {
+			t.Errorf("Locate(%q) = %d:%d, want %d:%d", c.at, pos.Line, pos.Column, c.line, c.col)
+		}
+	}
+
+	if txt, _ := sc.lineText(strings.Index(src, "ef")); txt != "cd ef" {
+		t.Errorf("line text = %q", txt)
+	}
+}
+
+func TestBOM(t *testing.T) {
+	src := "\uFEFFoption a"
+	for _, sc := range []*Scanner{
+		scanString(src),
+		ScanReader(io.NopCloser(iotest.OneByteReader(strings.NewReader(src)))),
+	} {
+		if !sc.AcceptString("option") {
+			t.Fatalf("keyword not found at start of input: %q", sc.window())
+		}
+		if pos := sc.Locate(sc.off); pos.Offset != 6 || pos.Column != 7 {
+			t.Errorf("position after keyword = %+v", pos)
+		}
+	}
+
+	// a lone byte order mark is empty input
+	sc := ScanReader(io.NopCloser(strings.NewReader("\uFEFF")))
+	if r := sc.Peek(); r != utf8.RuneError {
+		t.Errorf("Peek = %q", r)
+	}
+}
+
+func TestTokenGoString(t *testing.T) {
+	toks := scanTokens(scanString("a = 12 \"x"))
+	want := []string{
+		`parsekit.Token{Type: -1, Lexeme: "a", Pos: <input>:1:1}`,
+		`parsekit.Token{Type: '=', Lexeme: "=", Pos: <input>:1:3}`,
+		`parsekit.Token{Type: -2, Value: int64(12), Lexeme: "12", Pos: <input>:1:5}`,
+		`parsekit.Token{Value: error("unterminated string"), Lexeme: "\"x", Pos: <input>:1:8}`,
+		`parsekit.Token{Pos: <input>:1:10}`,
+	}
+	for i, tk := range toks {
+		if got := fmt.Sprintf("%#v", tk); i >= len(want) || got != want[i] {
+			t.Errorf("token %d = %s", i, got)
+		}
+	}
+	if got := fmt.Sprintf("%#v", EOF); got != "parsekit.Token{}" {
+		t.Errorf("EOF = %s", got)
+	}
+}
+
+func TestEmit(t *testing.T) {
+	tk := scanned("0xFF").Emit(1, func(s string) any { return strings.TrimPrefix(s, "0x") })
+	if tk.Type != 1 || tk.Value != "FF" {
+		t.Errorf("Emit = %#v", tk)
+	}
+}
+
+func TestInner(t *testing.T) {
+	cases := []struct{ in, inner, trim string }{
+		{`"abc"`, "abc", `"abc"`},
+		{`""`, "", `""`},
+		{`"`, "", `"`},
+		{" \tx y\n", "\tx y", "x y"},
+	}
+	for _, c := range cases {
+		sc := scanned(c.in)
+		if got := sc.Inner(); got != c.inner {
+			t.Errorf("Inner(%q) = %q, want %q", c.in, got, c.inner)
+		}
+		if got := sc.CursorTrim(); got != c.trim {
+			t.Errorf("CursorTrim(%q) = %q, want %q", c.in, got, c.trim)
+		}
+	}
+}
+
+func TestAutoNet(t *testing.T) {
+	if tk := Auto[net.IP](1, scanned("192.0.2.1")); !net.IPv4(192, 0, 2, 1).Equal(tk.Value.(net.IP)) {
+		t.Errorf("Auto[net.IP] = %#v", tk.Value)
+	}
+	mac := net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}
+	if tk := Auto[net.HardwareAddr](1, scanned("00:1a:2b:3c:4d:5e")); !bytes.Equal(tk.Value.(net.HardwareAddr), mac) {
+		t.Errorf("Auto[net.HardwareAddr] = %#v", tk.Value)
+	}
+	for _, in := range []string{"", "192.0.2", "10.0.0.256"} {
+		if tk := Auto[net.IP](1, scanned(in)); tk.Type != 0 {
+			t.Errorf("Auto[net.IP](%q) should return an error token, got %#v", in, tk.Value)
+		}
+	}
+	if tk := Auto[net.HardwareAddr](1, scanned("00:1a:2b")); tk.Type != 0 {
+		t.Errorf("Auto[net.HardwareAddr] should return an error token, got %#v", tk.Value)
+	}
+
+	// values are slices, which cannot be compared: tokens go through the parser all the same
+	src := "00:1a:2b:3c:4d:5e 00:1a:2b:3c:4d:5f"
+	p := Init[[]string](ReadString(src), WithLexer(func(sc *Scanner) Token {
+		if sc.AcceptFunc(func(r rune) bool { return r != ' ' }) > 0 {
+			return Auto[net.HardwareAddr](1, sc)
+		}
+		sc.Advance()
+		return Ignore
+	}))
+	for p.More() {
+		p.Value = append(p.Value, ExpectValue[[]string, net.HardwareAddr](p, 1, "MAC address").String())
+	}
+	if v, err := p.Finish(); len(v) != 2 || err != nil {
+		t.Errorf("Finish() = %v, %v", v, err)
+	}
+}
+
+func TestAutoString(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{`"a\tb\"c"`, "a\tb\"c"},
+		{`"café"`, "café"},
+		{"`a\\tb`", `a\tb`}, // backslashes are literal in raw strings
+		{`'hello'`, "hello"},
+		{`'it\'s "quoted"'`, `it's "quoted"`},
+		{`'\x41\n'`, "A\n"},
+		{`bare`, "bare"},
+	}
+	for _, c := range cases {
+		if tk := Auto[string](1, scanned(c.in)); tk.Type != 1 || tk.Value != c.want {
+			t.Errorf("Auto[string](%s) = %#v, want %q", c.in, tk.Value, c.want)
+		}
+	}
+
+	for _, in := range []string{`"unterminated`, `'bad \q'`, `'`, `"a"b"`} {
+		if tk := Auto[string](1, scanned(in)); tk.Type != 0 {
+			t.Errorf("Auto[string](%s) should return an error token, got %#v", in, tk.Value)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package parsekit
+
+import (
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+)
+
+// This file contains middlewares over token streams.
+// They run between the lexer and the parser, see [WithTokenFilter].
+
+// Filter returns the tokens of seq for which keep returns true.
+func Filter(seq iter.Seq[Token], keep func(Token) bool) iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		for tk := range seq {
+			if keep(tk) && !yield(tk) {
+				return
+			}
+		}
+	}
+}
+
+// Map returns the tokens of seq, transformed by fn.
+func Map(seq iter.Seq[Token], fn func(Token) Token) iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		for tk := range seq {
+			if !yield(fn(tk)) {
+				return
+			}
+		}
+	}
+}
+
+// Layout synthesizes indentation tokens for whitespace-sensitive grammars, in the style of Python.
+// When the first token of a line is indented deeper than the previous line, a token indentTok is emitted before it.
+// When it is indented less, a token dedentTok is emitted for each indentation level closed.
+// When the input ends, tokens dedentTok are emitted to close all open levels.
+//
+// Indentation is the column of the first token on the line, see [WithTabWidth] to count tabs.
+// Dedenting to a level that was never opened is reported as an error.
+func Layout(seq iter.Seq[Token], indentTok, dedentTok rune) iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		levels := []int{1}
+		var line Position // first token of the current line
+		for tk := range seq {
+			if tk.IsEOF() {
+				for range levels[1:] {
+					if !yield(Token{Type: dedentTok, Pos: tk.Pos}) {
+						return
+					}
+				}
+				yield(tk)
+				return
+			}
+
+			// lines are numbered again in each file (see [ReadFiles]), and after line directives
+			if tk.Pos.Line != line.Line || tk.Pos.Filename != line.Filename {
+				line = tk.Pos
+				col := tk.Pos.Column
+				switch {
+				case col > levels[len(levels)-1]:
+					levels = append(levels, col)
+					if !yield(Token{Type: indentTok, Pos: tk.Pos}) {
+						return
+					}
+				case col < levels[len(levels)-1]:
+					for len(levels) > 1 && col < levels[len(levels)-1] {
+						levels = levels[:len(levels)-1]
+						if !yield(Token{Type: dedentTok, Pos: tk.Pos}) {
+							return
+						}
+					}
+					if col != levels[len(levels)-1] {
+						err := Errorf("inconsistent indentation")
+						err.Pos = tk.Pos
+						if !yield(err) {
+							return
+						}
+					}
+				}
+			}
+
+			if !yield(tk) {
+				return
+			}
+		}
+	}
+}
+
+// DumpTokens writes the tokens of seq to w, one per line, for debugging lexers:
+//
+//	IDENT(-1) pos=1:1 lexeme="interface"
+//	'{'(123) pos=1:11 lexeme="{"
+//	EOF(0) pos=1:12 lexeme=""
+//
+// Token types are rendered with names, if they are listed; single characters are quoted.
+// Values other than the lexeme are appended, as well as errors.
+func DumpTokens(w io.Writer, seq iter.Seq[Token], names map[rune]string) {
+	for tk := range seq {
+		fmt.Fprintf(w, "%s(%d) pos=%d:%d lexeme=%q", tokenName(tk, names), tk.Type, tk.Pos.Line, tk.Pos.Column, tk.Lexeme)
+		if tk.Value != nil && tk.Value != any(tk.Lexeme) {
+			fmt.Fprintf(w, " value=%v", tk.Value)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// tokenName returns a human-readable name for the type of tk.
+func tokenName(tk Token, names map[rune]string) string {
+	switch name, ok := names[tk.Type]; {
+	case ok:
+		return name
+	case tk.Type > 0 && strconv.IsPrint(tk.Type):
+		return strconv.QuoteRune(tk.Type)
+	case tk.Error() != nil:
+		return "ERROR"
+	case tk.Type == 0:
+		return "EOF"
+	}
+	return "TOKEN"
+}
@@ -0,0 +1,101 @@
+package parsekit
+
+import (
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTokenFilter(t *testing.T) {
+	dropCommas := func(seq iter.Seq[Token]) iter.Seq[Token] {
+		return Filter(seq, func(tk Token) bool { return tk.Type != ',' })
+	}
+	upper := func(seq iter.Seq[Token]) iter.Seq[Token] {
+		return Map(seq, func(tk Token) Token {
+			tk.Lexeme = strings.ToUpper(tk.Lexeme)
+			return tk
+		})
+	}
+
+	p := initTest("a, b,, c", WithTokenFilter(dropCommas), WithTokenFilter(upper))
+	for p.More() {
+		p.Expect(identTk, "word")
+		p.Value = append(p.Value, p.Lit())
+	}
+	if v, err := p.Finish(); !slices.Equal(v, []string{"A", "B", "C"}) || err != nil {
+		t.Errorf("Finish = %v, %v", v, err)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	const (
+		indentTk rune = -100 - iota
+		dedentTk
+	)
+	parse := func(src string, opts ...ParserOptions) ([]string, error) {
+		p := initTest(src, append(opts, WithTokenFilter(func(seq iter.Seq[Token]) iter.Seq[Token] {
+			return Layout(seq, indentTk, dedentTk)
+		}))...)
+
+		// stmt = word [':' INDENT stmt+ DEDENT]
+		var stmt func()
+		stmt = func() {
+			p.Expect(identTk, "statement")
+			name := p.Lit()
+			if !p.Match(':') {
+				p.Value = append(p.Value, name)
+				return
+			}
+			p.Value = append(p.Value, "begin "+name)
+			p.Expect(indentTk, "indented block")
+			p.Repeat(dedentTk, stmt)
+			p.Value = append(p.Value, "end "+name)
+		}
+
+		func() {
+			defer p.Synchronize()
+			for p.More() {
+				stmt()
+			}
+		}()
+		return p.Finish()
+	}
+
+	v, err := parse("if:\n  a\n  b:\n    c\n    d\n  e\n  f:\n    g\nh")
+	want := []string{"begin if", "a", "begin b", "c", "d", "end b", "e", "begin f", "g", "end f", "end if", "h"}
+	if !slices.Equal(v, want) || err != nil {
+		t.Errorf("Finish = %v, %v\nwant %v", v, err, want)
+	}
+
+	if _, err := parse("if:\n    a\n  b\n"); err == nil || !strings.Contains(err.Error(), "3:3: inconsistent indentation") {
+		t.Errorf("error = %v", err)
+	}
+
+	// line numbers start again in each file
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.conf")
+	os.WriteFile(first, []byte("if:\n  a\nb\n"), 0o644)
+	os.WriteFile(second, []byte("c:\n  d\ne"), 0o644)
+	v, err = parse("", ReadFiles(first, second))
+	want = []string{"begin if", "a", "end if", "b", "begin c", "d", "end c", "e"}
+	if !slices.Equal(v, want) || err != nil {
+		t.Errorf("files: Finish = %v, %v\nwant %v", v, err, want)
+	}
+}
+
+func TestDumpTokens(t *testing.T) {
+	var b strings.Builder
+	DumpTokens(&b, scanString("ab 12\n=\"x").Tokens(lextest), map[rune]string{identTk: "IDENT"})
+	want := `IDENT(-1) pos=1:1 lexeme="ab"
+TOKEN(-2) pos=1:4 lexeme="12" value=12
+'='(61) pos=2:1 lexeme="="
+ERROR(0) pos=2:2 lexeme="\"x" value=unterminated string
+EOF(0) pos=2:4 lexeme=""
+`
+	if b.String() != want {
+		t.Errorf("DumpTokens:\n%s\nwant\n%s", b.String(), want)
+	}
+}